@@ -0,0 +1,74 @@
+package rison
+
+import (
+	"hash"
+	"hash/fnv"
+	"reflect"
+)
+
+// Hash returns the 64-bit FNV-1a hash of the canonical form of the
+// Rison-encoded data, so that equal values have the same hash
+// regardless of the order of the object keys or the formatting of
+// the numbers and strings.
+func Hash(data []byte, m Mode) (uint64, error) {
+	return HashWith(data, m, fnv.New64a())
+}
+
+// HashWith is like Hash but uses h to compute the hash.
+// h is reset before use.
+func HashWith(data []byte, m Mode, h hash.Hash64) (uint64, error) {
+	c, err := Canonicalize(data, m)
+	if err != nil {
+		return 0, err
+	}
+	h.Reset()
+	_, err = h.Write(c)
+	if err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+// Canonicalize returns the canonical form of the Rison-encoded data,
+// with the object keys sorted and the numbers and strings in their
+// shortest forms, so that equal values have the same bytes regardless
+// of how they were written, such as for cache keys. Canonicalizing the
+// canonical form again returns the same bytes.
+func Canonicalize(data []byte, m Mode) ([]byte, error) {
+	_, c, err := DecodeCanonical(data, m)
+	return c, err
+}
+
+// DecodeCanonical parses the Rison-encoded data once and returns both
+// the decoded value, as Decode does, and its canonical Rison encoding,
+// with the object keys sorted and the numbers and strings in their
+// shortest forms, such as a cache key. The canonical encoding is made
+// from the decoded tree without parsing the data again.
+func DecodeCanonical(data []byte, m Mode) (value interface{}, canonical []byte, err error) {
+	value, err = Decode(data, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	canonical, err = EncodeTree(value, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, canonical, nil
+}
+
+// Equal reports whether the Rison-encoded data a and b represent the
+// same value, regardless of the order of the object keys or the
+// formatting of the numbers and strings; 1.0 and 1 are equal since
+// both are decoded into the same float64. It fails if either of them
+// is invalid.
+func Equal(a, b []byte, m Mode) (bool, error) {
+	va, err := Decode(a, m)
+	if err != nil {
+		return false, err
+	}
+	vb, err := Decode(b, m)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(va, vb), nil
+}
@@ -0,0 +1,166 @@
+package rison
+
+import (
+	"bytes"
+	"hash/crc64"
+	"reflect"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	equals := [][]string{
+		{"(a:1,b:2)", "(b:2,a:1)", "(a:1.0,b:2e0)"},
+		{"!(a,'b',1.50)", "!('a',b,15e-1)"},
+	}
+	for i, rs := range equals {
+		for _, r := range rs {
+			for _, o := range rs {
+				hr, err := Hash([]byte(r), Rison)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ho, err := Hash([]byte(o), Rison)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hr != ho {
+					t.Errorf("hashing %s and %s : want equal hashes, got %x and %x", r, o, hr, ho)
+				}
+			}
+			if i == 0 {
+				continue
+			}
+			hr, _ := Hash([]byte(r), Rison)
+			ho, _ := Hash([]byte(equals[0][0]), Rison)
+			if hr == ho {
+				t.Errorf("hashing %s and %s : want different hashes, got %x", r, equals[0][0], hr)
+			}
+		}
+	}
+
+	differents := []string{"(a:1,b:2)", "(a:1,b:3)", "(a:1)", "(a:'1',b:2)", "!(1,2)", "!(2,1)"}
+	seen := map[uint64]string{}
+	for _, r := range differents {
+		h, err := Hash([]byte(r), Rison)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o, ok := seen[h]; ok {
+			t.Errorf("hashing %s and %s : want different hashes, got %x", r, o, h)
+		}
+		seen[h] = r
+	}
+
+	h1, err := HashWith([]byte("b:2,a:1"), ORison, crc64.New(crc64.MakeTable(crc64.ECMA)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := HashWith([]byte("a:1,b:2"), ORison, crc64.New(crc64.MakeTable(crc64.ECMA)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 != h2 {
+		t.Errorf("hashing with crc64 : want equal hashes, got %x and %x", h1, h2)
+	}
+
+	_, err = Hash([]byte("(a:1"), Rison)
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("hashing (a:1 : want *ParseError, got %#v", err)
+	}
+}
+
+func TestDecodeCanonical(t *testing.T) {
+	cases := []struct {
+		r    string
+		m    Mode
+		want string
+	}{
+		{"(b:2,a:1.0)", Rison, "(a:1,b:2)"},
+		{"!('a',15e-1,(y:!t,x:'it!'s'))", Rison, "!(a,1.5,(x:'it!'s',y:!t))"},
+		{"'abc'", Rison, "abc"},
+		{"z:!n,a:!(2e0)", ORison, "a:!(2),z:!n"},
+		{"1.0,'-x'", ARison, "1,'-x'"},
+	}
+	for _, c := range cases {
+		v, canonical, err := DecodeCanonical([]byte(c.r), c.m)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", c.r, err.Error())
+			continue
+		}
+		if string(canonical) != c.want {
+			t.Errorf("decoding %s : want the canonical %s, got %s", c.r, c.want, canonical)
+		}
+		want, _ := Decode([]byte(c.r), c.m)
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", c.r, dumpValue(want), dumpValue(v))
+		}
+		r, _ := Marshal(want, c.m)
+		if !bytes.Equal(canonical, r) {
+			t.Errorf("decoding %s : want the canonical same as Marshal %s, got %s", c.r, r, canonical)
+		}
+	}
+
+	v, canonical, err := DecodeCanonical([]byte("(a:1"), Rison)
+	if _, ok := err.(*ParseError); !ok || v != nil || canonical != nil {
+		t.Errorf("decoding (a:1 : want *ParseError, got %v, %s, %#v", v, canonical, err)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	c, err := Canonicalize([]byte("(b:1,a:0)"), Rison)
+	if err != nil || string(c) != "(a:0,b:1)" {
+		t.Errorf("canonicalizing (b:1,a:0) : want (a:0,b:1), got %s, %v", c, err)
+	}
+
+	for r := range testCases {
+		c, err := Canonicalize([]byte(r), Rison)
+		if err != nil {
+			t.Errorf("canonicalizing %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		again, err := Canonicalize(c, Rison)
+		if err != nil || !bytes.Equal(again, c) {
+			t.Errorf("canonicalizing %s again : want %s, got %s, %v", r, c, again, err)
+		}
+		want, _ := Decode([]byte(r), Rison)
+		v, _ := Decode(c, Rison)
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("canonicalizing %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+	}
+
+	if _, err := Canonicalize([]byte("(a:1"), Rison); err == nil {
+		t.Errorf("canonicalizing (a:1 : want an error, got nil")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		a, b string
+		m    Mode
+		want bool
+	}{
+		{"(a:0,b:1)", "(b:1,a:0)", Rison, true},
+		{"!(1.0,2e0,-0.5)", "!(1,2,-5e-1)", Rison, true},
+		{"(x:'abc',y:!(!t,!n))", "(y:!(!t,!n),x:abc)", Rison, true},
+		{"b:(d:1,c:2),a:!()", "a:!(),b:(c:2,d:1)", ORison, true},
+		{"(a:0,b:1)", "(a:0,b:2)", Rison, false},
+		{"(a:0)", "(a:0,b:1)", Rison, false},
+		{"!(1,2)", "!(2,1)", Rison, false},
+		{"1", "'1'", Rison, false},
+		{"!n", "()", Rison, false},
+		{"1,2", "1,2,3", ARison, false},
+	}
+	for _, c := range cases {
+		got, err := Equal([]byte(c.a), []byte(c.b), c.m)
+		if err != nil || got != c.want {
+			t.Errorf("comparing %s and %s : want %v, got %v, %v", c.a, c.b, c.want, got, err)
+		}
+	}
+
+	for _, ab := range [][2]string{{"(a:1", "(a:1)"}, {"(a:1)", "!x"}} {
+		if _, err := Equal([]byte(ab[0]), []byte(ab[1]), Rison); err == nil {
+			t.Errorf("comparing %s and %s : want an error, got nil", ab[0], ab[1])
+		}
+	}
+}
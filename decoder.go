@@ -2,47 +2,451 @@ package rison
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
+// DecodeOptions specifies the optional behaviors of decoding.
+// The zero value is the default behavior, which accepts only the
+// standard Rison.
+type DecodeOptions struct {
+	// LenientStringEscapes makes the decoder accept an unknown escape
+	// sequence such as "!n" in a quoted string and keep it as the two
+	// characters "!n", instead of failing with EInvalidStringEscape.
+	// Such a sequence is ambiguous; other implementations may take it
+	// as the character after "!" or reject it.
+	LenientStringEscapes bool
+
+	// OnPrecisionLoss, if set, is called with an integer as written in
+	// the input, such as 9007199254740993, when it cannot be represented
+	// exactly as float64. The integer is decoded to the nearest float64
+	// anyway; the callback is only for logging or rejecting such inputs.
+	OnPrecisionLoss func(raw []byte)
+
+	// TrimOuterShellQuotes makes the decoder strip the single quotes
+	// wrapping the whole input, which are often left when Rison is
+	// copied from a shell command line, such as '(a:1)'.
+	// They are stripped only if the content between them is valid
+	// Rison, and in the Rison mode, it is an object or an array.
+	// This is a heuristic: the input '(a:1)' is also a valid Rison
+	// string "(a:1)", which can no longer be decoded as such.
+	TrimOuterShellQuotes bool
+
+	// LiteralAliases maps ids (bare strings) to the values they are
+	// decoded to, such as {"yes": true, "no": false, "nil": nil}
+	// for a dialect of Rison. The aliases shadow the ids used as
+	// values, but not the object keys nor the quoted strings; 'yes'
+	// is still decoded to the string "yes".
+	// The values must be nil, booleans, numbers or strings.
+	LiteralAliases map[string]interface{}
+
+	// DisallowEmptyKeys makes the decoder reject an empty object key
+	// such as ('':1) with EEmptyKey.
+	DisallowEmptyKeys bool
+
+	// NormalizeSmartQuotes makes the decoder replace the typographic
+	// quotes often substituted by word processors with the ASCII ones
+	// before parsing: U+2018 and U+2019 with "'", and U+201C and U+201D
+	// with '"'. This is a pre-processing normalization applied to the
+	// whole input, so a U+2019 used as an apostrophe in a string ends
+	// the string, and the positions in errors are the ones in the
+	// normalized input.
+	NormalizeSmartQuotes bool
+
+	// ProtoJSONCompatible makes the decoder write the integers out of
+	// the range where float64 is exact (beyond ±(2^53-1)) as strings in
+	// the JSON, like int64 and uint64 values in the proto3 JSON mapping,
+	// so that they are decoded into such fields without loss of
+	// precision. Only the integers written without a fraction or an
+	// exponent are affected. This is not a full proto3 JSON mapping;
+	// the other values, such as enums, are left as they are.
+	ProtoJSONCompatible bool
+
+	// KeyOperatorChars is the set of characters allowed in bare object
+	// keys after the first character, in addition to the ones allowed
+	// in ids, for dialects appending operators to keys such as
+	// (size*:100) or (name@:x). Only "*", "@" and "$" take effect;
+	// the other characters excluded from ids are the delimiters of Rison.
+	// Note that "~" and "-" are allowed in ids already, so the keys such
+	// as availability~ and size_gib-GE need no option.
+	KeyOperatorChars string
+
+	// MaxKeys, if positive, is the maximum number of keys in each
+	// object. The decoder fails with EKeysExceeded at the first key
+	// beyond the limit. The duplicated keys are counted as well.
+	MaxKeys int
+
+	// AllowComments makes the decoder skip the comments from "#" or "//"
+	// to the end of the line and the ones between "/*" and "*/", and the
+	// whitespaces, between the tokens, for the hand-written Rison of
+	// config-style dialects spanning multiple lines. The comments are not
+	// recognized in quoted strings nor in ids; write a whitespace between
+	// an id and the comment following it. Note that an id starting with
+	// "#" or "/" such as #fff must be quoted with the option. A "/*"
+	// without "*/" is EUnmatchedPair.
+	AllowComments bool
+
+	// SkipWhitespaces makes the decoder skip the whitespaces (" ", "\t",
+	// "\n", "\r" and "\f") between the tokens, for the pretty-printed or
+	// line-wrapped Rison such as ( a : 1 , b : 2 ). The whitespaces in
+	// quoted strings are kept, and the ones in ids end the ids.
+	SkipWhitespaces bool
+
+	// MaxStringLen, if positive, is the maximum length in bytes of each
+	// string, quoted or bare, including the object keys. The decoder
+	// fails with EStringTooLong at the start of the first string beyond
+	// the limit, without accumulating the rest of it. The length of a
+	// quoted string is counted after unescaping.
+	MaxStringLen int
+
+	// MaxInputBytes, if positive, is the maximum length in bytes of the
+	// input. The decoder fails with EInputTooLong at the first byte
+	// beyond the limit before parsing anything. The limit applies to
+	// the input as given, before TrimOuterShellQuotes and the wrapping
+	// of the O-Rison and the A-Rison. The length of each decoded string
+	// is limited by MaxStringLen, which serves as the MaxStringBytes
+	// counterpart of this limit.
+	MaxInputBytes int
+
+	// AllowDoubleQuotes makes the decoder accept the strings quoted with
+	// '"' by the JSON rules, such as (a:"it's \"x\"\n"), in addition to
+	// the ones quoted with "'", for the inputs written by JSON users.
+	// By default, '"' is an ordinary character of ids, so (a:"x") is
+	// decoded to {"a":"\"x\""}. Even with the option, '"' is kept in
+	// the middle of ids, such as a"b.
+	AllowDoubleQuotes bool
+
+	// UseNumber makes the decoder keep the numbers as written, like
+	// UseNumber of json.Decoder: Decode returns them as json.Number
+	// instead of float64, ToJSON writes them as they are instead of in
+	// the shortest form, and Unmarshal stores them into interface{} as
+	// json.Number. Thus the integers beyond 2^53, such as 64-bit IDs,
+	// are decoded without loss of precision, including into int64 and
+	// uint64.
+	UseNumber bool
+
+	// MaxTotalStringBytes, if positive, is the maximum sum of the lengths
+	// in bytes of all the strings, quoted or bare, including the object
+	// keys. The decoder fails with EStringBytesExceeded at the start of
+	// the string beyond the limit. Unlike MaxStringLen, it bounds the
+	// input of many short strings as well.
+	MaxTotalStringBytes int
+
+	// TimeFormat is the format of the time.Time values decoded by
+	// Unmarshal. With TimeUnixSeconds, the numbers are decoded into
+	// time.Time as the seconds since the Unix epoch, in UTC.
+	TimeFormat TimeFormat
+
+	// RequireMinimalNumbers makes the decoder fail with
+	// ENonMinimalNumber on the numbers not written in the shortest form
+	// as Marshal writes them, such as 1.0, 1.50 and 1e2 for 100, to
+	// enforce a stable form of the input. The integers out of the range
+	// where float64 is exact are accepted as written.
+	RequireMinimalNumbers bool
+
+	// AllowUppercaseExponent makes the decoder accept "E" for the
+	// exponents of numbers as well as "e", such as 1.5E2, written by
+	// some non-conforming encoders. By default, it fails with
+	// EInvalidLargeExp.
+	AllowUppercaseExponent bool
+
+	// AllowPlusExponent makes the decoder accept "+" at the start of the
+	// exponents of numbers, such as 1e+30, written by some other Rison
+	// libraries. By default, such numbers are invalid.
+	AllowPlusExponent bool
+
+	// InternCommonValues makes Decode share the interface{} values of
+	// the integers from 0 to 255 among the decoded trees instead of
+	// allocating each of them, which reduces the allocations for the
+	// documents full of small integers. The booleans, null and empty
+	// strings never allocate anyway. The returned trees must be treated
+	// as read-only. It has no effect with UseNumber or on Unmarshal.
+	InternCommonValues bool
+
+	// KeepWrappedSrc makes the decoder keep the input as parsed
+	// internally in ParseError.WrappedSrc, for debugging the positions
+	// of the errors in the O-Rison and A-Rison modes.
+	KeepWrappedSrc bool
+
+	// MaxDepth, if positive, is the maximum nesting depth of arrays and
+	// objects, to protect the stack from deeply nested inputs. The
+	// decoder fails with EMaxDepthExceeded at the start of the first
+	// array or object nested deeper. The top-level array or object,
+	// including the ones of the O-Rison and the A-Rison, is at depth 1.
+	MaxDepth int
+
+	// DisallowDuplicateKeys makes the decoder fail with EDuplicateKey at
+	// the second occurrence of a key in an object, such as (a:1,a:2),
+	// instead of taking the last value. The same keys in different
+	// objects, such as (a:(a:1)), are allowed.
+	DisallowDuplicateKeys bool
+
+	// ReportLeadingZeros makes the decoder fail with ELeadingZero at the
+	// first zero of the numbers such as 01 and -01, which are invalid as
+	// in JSON, to tell the reason clearly instead of EInvalidNumber.
+	ReportLeadingZeros bool
+
+	// DisallowUnknownFields makes Unmarshal fail with EUnknownField at
+	// the object keys with no matching fields in the structs to be
+	// decoded into, as json.Decoder.DisallowUnknownFields does, instead
+	// of dropping them. A struct with the rest field accepts any keys.
+	DisallowUnknownFields bool
+}
+
+var smartQuoteReplacer = strings.NewReplacer(
+	"\u2018", "'",
+	"\u2019", "'",
+	"\u201c", `"`,
+	"\u201d", `"`,
+)
+
 // Unmarshal parses the Rison-encoded data and stores the result
 // in the value pointed to by v.
 //
 // The object keys corresponding the struct fields can be
 // specified in struct tag (not "rison" but) "json".
+//
+// When a value cannot be stored in the Go value of the corresponding
+// type, the error is a *ParseError of ETypeMismatch pointing at the
+// value in data.
+//
+// The values of the types implementing Unmarshaler are decoded by
+// their UnmarshalRison methods; the other values are decoded in the
+// same way as "encoding/json".
+//
+// A field of type map[string]interface{} tagged `rison:",rest"` is set
+// to a new map of the members whose keys match none of the other fields
+// of the struct, or to nil if there are no such members. Marshal writes
+// the members in it along with the other fields, except the ones with
+// the same keys as the other fields. The field must be of the struct
+// itself, not of an embedded struct.
 func Unmarshal(data []byte, v interface{}, m Mode) error {
-	j, err := ToJSON(data, m)
+	return UnmarshalWithOptions(data, v, m, DecodeOptions{})
+}
+
+// UnmarshalWithOptions is like Unmarshal but decodes with the options.
+func UnmarshalWithOptions(data []byte, v interface{}, m Mode, opts DecodeOptions) error {
+	p := &parser{Mode: m, DecodeOptions: opts, recordPositions: true}
+	j, err := p.parse(data)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(j, v)
+	return p.unmarshalJSON(j, v)
+}
+
+// UnmarshalStrict is like Unmarshal but fails on the object keys with
+// no matching struct fields. See DecodeOptions.DisallowUnknownFields.
+func UnmarshalStrict(data []byte, v interface{}, m Mode) error {
+	return UnmarshalWithOptions(data, v, m, DecodeOptions{DisallowUnknownFields: true})
+}
+
+// DecodeTo parses the Rison-encoded data and returns the result
+// stored in a new value of type T. See Unmarshal for the details.
+//
+// In the O-Rison and A-Rison modes, T must be a type that can hold
+// an object or an array respectively. If T is a pointer type, the
+// result points to a newly allocated value, or is nil for "!n".
+func DecodeTo[T any](data []byte, m Mode) (T, error) {
+	var v T
+	err := checkTypeMatchesMode(reflect.TypeOf(&v).Elem(), m)
+	if err != nil {
+		return v, err
+	}
+	err = Unmarshal(data, &v, m)
+	return v, err
+}
+
+// DecodeTyped is the same as DecodeTo, named for the symmetry with
+// EncodeTyped in generic code.
+func DecodeTyped[T any](data []byte, m Mode) (T, error) {
+	return DecodeTo[T](data, m)
+}
+
+func checkTypeMatchesMode(t reflect.Type, mode Mode) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return nil
+	}
+	switch mode {
+	case ORison:
+		if !(t.Kind() == reflect.Struct || t.Kind() == reflect.Map) {
+			return fmt.Errorf("the O-Rison can be decoded only into a struct or a map, not %s", t)
+		}
+	case ARison:
+		if !(t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			return fmt.Errorf("the A-Rison can be decoded only into a slice or an array, not %s", t)
+		}
+	}
+	return nil
 }
 
 // ToJSON parses the Rison-encoded data and returns the
 // JSON-encoded data that expresses the equal value.
 func ToJSON(data []byte, m Mode) ([]byte, error) {
-	return (&parser{Mode: m}).parse(data)
+	return ToJSONWithOptions(data, m, DecodeOptions{})
+}
+
+// ToJSONWithOptions is like ToJSON but decodes with the options.
+func ToJSONWithOptions(data []byte, m Mode, opts DecodeOptions) ([]byte, error) {
+	return (&parser{Mode: m, DecodeOptions: opts}).parse(data)
 }
 
 // Decode parses the Rison-encoded data and returns the
 // result as the tree of map[string]interface{}
 // (or []interface{} or scalar value).
 func Decode(data []byte, m Mode) (interface{}, error) {
-	j, err := ToJSON(data, m)
+	return DecodeWithOptions(data, m, DecodeOptions{})
+}
+
+// DecodeWithOptions is like Decode but decodes with the options.
+//
+// The tree is built directly while parsing, without the JSON encoding.
+func DecodeWithOptions(data []byte, m Mode, opts DecodeOptions) (interface{}, error) {
+	b := &treeBuilder{useNumber: opts.UseNumber, intern: opts.InternCommonValues}
+	err := (&parser{Mode: m, DecodeOptions: opts}).walk(data, b)
 	if err != nil {
 		return nil, err
 	}
-	var o interface{}
-	err = json.Unmarshal(j, &o)
+	return b.root, nil
+}
+
+// DecodeContext is like Decode but stops parsing when the context is
+// done, returning the error of the context. The context is checked
+// every so many values, so that parsing a large input can be abandoned
+// with the request.
+func DecodeContext(ctx context.Context, data []byte, m Mode) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b := &treeBuilder{}
+	err := (&parser{Mode: m, ctx: ctx}).walk(data, b)
+	if err != nil {
+		return nil, err
+	}
+	return b.root, nil
+}
+
+// DecodeAutoObject parses an object encoded either in the Rison such as
+// (a:1) or in the O-Rison such as a:1, and returns the decoded map.
+// The input starting with "(" is taken as the Rison, since an O-Rison
+// input cannot start with it. The other values are errors.
+func DecodeAutoObject(data []byte) (map[string]interface{}, error) {
+	m := ORison
+	if bytes.HasPrefix(data, []byte("(")) {
+		m = Rison
+	}
+	var o map[string]interface{}
+	err := Unmarshal(data, &o, m)
 	if err != nil {
 		return nil, err
 	}
 	return o, nil
 }
 
+// DecodeToSyncMap parses the Rison-encoded object and stores its members
+// in a new sync.Map, keyed by the strings, for the values read by many
+// goroutines such as configurations loaded once.
+// It fails if the value is not an object, including !n.
+func DecodeToSyncMap(data []byte, m Mode) (*sync.Map, error) {
+	v, err := Decode(data, m)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("only an object can be decoded into a sync.Map, not %T", v)
+	}
+	sm := &sync.Map{}
+	for k, v := range o {
+		sm.Store(k, v)
+	}
+	return sm, nil
+}
+
+// Validate checks that the Rison-encoded data is valid, without
+// building the decoded value nor the JSON. It returns nil if and only
+// if Decode succeeds, and the same *ParseError as Decode otherwise.
+func Validate(data []byte, m Mode) error {
+	return (&parser{Mode: m}).walk(data, discard{})
+}
+
+// ValidateReader reads the Rison-encoded data from r and checks
+// that it is valid, without building the decoded value.
+// It returns the same *ParseError as Decode would on failure.
+//
+// The parser needs random access to the input, so the whole input
+// is read into memory first. The memory used is bounded by the size
+// of the input; no JSON output is produced.
+func ValidateReader(r io.Reader, m Mode) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return Validate(data, m)
+}
+
+// DecodeDelimited splits data into the Rison-encoded documents
+// separated by delim, and decodes each of them like Decode.
+// The i-th elements of the returned slices are the result of
+// the i-th document; the error is nil if it is decoded successfully.
+// The positions in the errors are relative to the document.
+//
+// The delimiter is recognized only outside of quoted strings,
+// so a document such as 'a;b' is not split by ';'. Since the
+// delimiter can appear unescaped inside quoted strings, it must
+// not be one of the characters that quote strings, "'" and "!";
+// for them, the whole data is reported as a document failing with the
+// error. A control character such as '\x1e' (record separator) never
+// appears in valid Rison outside of quoted strings, so it is a
+// safe choice. An empty document after the last delimiter is ignored.
+func DecodeDelimited(data []byte, delim byte, m Mode) ([]interface{}, []error) {
+	docs, err := splitDelimited(data, delim)
+	if err != nil {
+		return []interface{}{nil}, []error{err}
+	}
+	values := make([]interface{}, len(docs))
+	errs := make([]error, len(docs))
+	for i, doc := range docs {
+		values[i], errs[i] = Decode(doc, m)
+	}
+	return values, errs
+}
+
+func splitDelimited(data []byte, delim byte) ([][]byte, error) {
+	if delim == '\'' || delim == '!' {
+		return nil, fmt.Errorf("the delimiter %q quotes strings", delim)
+	}
+	docs := [][]byte{}
+	start := 0
+	quoted := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case quoted && c == '!':
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case !quoted && c == delim:
+			docs = append(docs, data[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		docs = append(docs, data[start:])
+	}
+	return docs, nil
+}
+
 func substr(str []byte, o, n int) []byte {
 	s := len(str)
 	if s == 0 {
@@ -77,15 +481,75 @@ func substrLimited(str []byte, o, n int) []byte {
 }
 
 type parser struct {
-	Mode            Mode
-	SkipWhitespaces bool
+	Mode Mode
+	DecodeOptions
 	string          []byte
 	index           int
-	buffer          *bytes.Buffer
+	h               handler
+	recordPositions bool
+	positions       []valuePos
+	inKey           bool
+	key             string
+	keyStart        int
+	path            []pathElem
+	stringBytes     int
+	depth           int
+	ctx             context.Context // checked every ctxCheckInterval values if not nil
+	values          int
+	openComment     int // the start of an unterminated "/*", or -1
+}
+
+// ctxCheckInterval is the number of values parsed between the checks
+// of the context given to DecodeContext.
+const ctxCheckInterval = 1024
+
+// pathElem is an object key or an array index in the path to a value.
+type pathElem struct {
+	key   string
+	index int // -1 for an object key
+}
+
+// jsonPath returns the JSONPath-like locator of the value being parsed,
+// such as $.filters[2].range.min.
+func (p *parser) jsonPath() string {
+	return formatJSONPath(p.path)
+}
+
+// formatJSONPath returns the JSONPath-like locator of the path.
+func formatJSONPath(path []pathElem) string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, e := range path {
+		if 0 <= e.index {
+			fmt.Fprintf(&b, "[%d]", e.index)
+		} else {
+			b.WriteString(jsonPathKey(e.key))
+		}
+	}
+	return b.String()
+}
+
+// jsonPathKey returns the key in the dot notation if possible,
+// or the bracket notation otherwise.
+func jsonPathKey(key string) string {
+	dot := key != ""
+	for i, c := range key {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || 0 < i && '0' <= c && c <= '9') {
+			dot = false
+			break
+		}
+	}
+	if dot {
+		return "." + key
+	}
+	return "['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(key) + "']"
 }
 
 func (p *parser) errorf(pos int, err error, typ ErrType, args ...interface{}) error {
-	i := p.index
+	return p.errorAt(p.index+pos, err, typ, args...)
+}
+
+func (p *parser) errorAt(i int, err error, typ ErrType, args ...interface{}) error {
 	src := p.string
 	switch p.Mode {
 	case ORison:
@@ -95,19 +559,147 @@ func (p *parser) errorf(pos int, err error, typ ErrType, args ...interface{}) er
 		src = substr(src, 2, -1)
 		i -= 2
 	}
-	pos += i
-	return &ParseError{
+	e := &ParseError{
 		Child: err,
 		Type:  typ,
 		Args:  args,
 		Src:   src,
-		Pos:   pos,
+		Pos:   i,
+		path:  p.jsonPath(),
+	}
+	if p.KeepWrappedSrc {
+		e.WrappedSrc = p.string
+	}
+	return e
+}
+
+// unmarshalJSON stores the JSON output j of the parser into v.
+// The errors on type mismatches are converted into *ParseError
+// pointing at the corresponding value in the Rison input.
+func (p *parser) unmarshalJSON(j []byte, v interface{}) error {
+	orig := j
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr {
+		err := p.checkTypes(j, t)
+		if err != nil {
+			return err
+		}
+		j, err = p.rewriteJSON(j, t)
+		if err != nil {
+			return err
+		}
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	if p.UseNumber {
+		d.UseNumber()
+	}
+	err := d.Decode(v)
+	if err == nil {
+		err = p.fillRison(orig, v)
+		if err != nil {
+			return err
+		}
+		return p.fillRest(orig, v)
+	}
+	e, ok := err.(*json.UnmarshalTypeError)
+	if !ok {
+		return err
+	}
+	i := p.risonIndex(int(e.Offset))
+	path := p.valuePath(i)
+	pe := p.errorAt(i, err, ETypeMismatch, e.Value, "."+e.Field, e.Type.String()).(*ParseError)
+	pe.path = formatJSONPath(path)
+	return pe
+}
+
+// valuePath returns the path to the value starting at the index i in
+// the input wrapped by the mode, by parsing the input again.
+func (p *parser) valuePath(i int) []pathElem {
+	opts := p.DecodeOptions
+	opts.MaxInputBytes = 0 // the wrapped input may be longer than the limit
+	f := &pathFinder{p: &parser{Mode: Rison, DecodeOptions: opts}, target: i}
+	_ = f.p.walk(p.string, f)
+	return f.path
+}
+
+// pathFinder is a handler which finds the path to the value starting
+// at the target index.
+type pathFinder struct {
+	p      *parser
+	target int
+	path   []pathElem
+}
+
+func (f *pathFinder) value(start int) {
+	if start == f.target && f.path == nil {
+		f.path = append([]pathElem{}, f.p.path...)
 	}
 }
 
+func (f *pathFinder) beginObject(start int)           { f.value(start) }
+func (f *pathFinder) endObject(end int)               {}
+func (f *pathFinder) beginArray(start int)            { f.value(start) }
+func (f *pathFinder) endArray(end int)                {}
+func (f *pathFinder) comma(pos int)                   {}
+func (f *pathFinder) colon(pos int)                   {}
+func (f *pathFinder) key(s []byte, start, end int)    {}
+func (f *pathFinder) null(start, end int)             { f.value(start) }
+func (f *pathFinder) boolean(v bool, start, end int)  { f.value(start) }
+func (f *pathFinder) number(j []byte, start, end int) { f.value(start) }
+func (f *pathFinder) string(s []byte, start, end int) { f.value(start) }
+
+// risonIndex returns the index in the Rison input of the last value
+// starting before the offset in the JSON output.
+func (p *parser) risonIndex(offset int) int {
+	n := sort.Search(len(p.positions), func(i int) bool {
+		return offset <= p.positions[i].json
+	})
+	if n == 0 {
+		return 0
+	}
+	return p.positions[n-1].rison
+}
+
+// parse parses the whole input and returns the JSON-encoded value.
 func (p *parser) parse(rison []byte) ([]byte, error) {
+	w := &jsonWriter{
+		buffer:          bytes.NewBuffer(make([]byte, 0, len(rison))),
+		recordPositions: p.recordPositions,
+	}
+	return p.parseTo(rison, w)
+}
+
+// parseTo is like parse but writes the JSON with w.
+func (p *parser) parseTo(rison []byte, w *jsonWriter) ([]byte, error) {
+	err := p.walk(rison, w)
+	p.positions = w.positions
+	if err != nil {
+		// the value is returned with the error about the trailing characters
+		if e, ok := err.(*ParseError); !ok || (e.Type != EExtraCharacterAfterRison && e.Type != EInvalidLargeExp) {
+			return nil, err
+		}
+	}
+	return w.buffer.Bytes(), err
+}
+
+// walk parses the whole input and passes the values to h.
+func (p *parser) walk(rison []byte, h handler) error {
+	if 0 < p.MaxInputBytes && p.MaxInputBytes < len(rison) {
+		return &ParseError{
+			Type: EInputTooLong,
+			Args: []interface{}{len(rison), p.MaxInputBytes},
+			Src:  rison,
+			Pos:  p.MaxInputBytes,
+		}
+	}
 	if !utf8.Valid(rison) {
-		return nil, p.errorf(0, nil, EEncoding)
+		return p.errorf(0, nil, EEncoding)
+	}
+
+	if p.NormalizeSmartQuotes {
+		rison = []byte(smartQuoteReplacer.Replace(string(rison)))
+	}
+	if p.TrimOuterShellQuotes {
+		rison = p.trimOuterShellQuotes(rison)
 	}
 
 	switch p.Mode {
@@ -120,21 +712,58 @@ func (p *parser) parse(rison []byte) ([]byte, error) {
 	}
 	p.string = rison
 	p.index = 0
-	p.buffer = bytes.NewBuffer(make([]byte, 0, len(rison)))
+	p.path = p.path[:0]
+	p.inKey = false
+	p.stringBytes = 0
+	p.depth = 0
+	p.values = 0
+	p.openComment = -1
+	p.h = h
+	defer func() {
+		p.h = nil
+	}()
 	typ, err := p.readValue()
+	if p.openComment < 0 && err == nil && (p.AllowComments || p.SkipWhitespaces) {
+		if _, ok := p.next(); ok {
+			p.index--
+		}
+	}
+	if 0 <= p.openComment {
+		// the rest of the input is hidden by the comment
+		return p.errorAt(p.openComment, nil, EUnmatchedPair, "/*")
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	j := p.buffer.Bytes()
-	p.buffer = nil
 	if p.index < len(p.string) {
 		c := p.string[p.index]
 		if typ == nodeTypeNumber && c == 'E' {
-			return j, p.errorf(0, nil, EInvalidLargeExp)
+			return p.errorf(0, nil, EInvalidLargeExp)
 		}
-		return j, p.errorf(0, nil, EExtraCharacterAfterRison, c)
+		return p.errorf(0, nil, EExtraCharacterAfterRison, c)
+	}
+	return nil
+}
+
+// trimOuterShellQuotes returns the content between the single quotes
+// wrapping the whole input if it is valid Rison (of an object or an
+// array in the Rison mode). Otherwise it returns the input as it is.
+func (p *parser) trimOuterShellQuotes(rison []byte) []byte {
+	n := len(rison)
+	if n < 2 || rison[0] != '\'' || rison[n-1] != '\'' {
+		return rison
+	}
+	inner := rison[1 : n-1]
+	if p.Mode == Rison && !(bytes.HasPrefix(inner, []byte("(")) || bytes.HasPrefix(inner, []byte("!("))) {
+		return rison
+	}
+	q := &parser{Mode: p.Mode, DecodeOptions: p.DecodeOptions}
+	q.TrimOuterShellQuotes = false
+	err := q.walk(inner, discard{})
+	if err != nil {
+		return rison
 	}
-	return j, nil
+	return inner
 }
 
 type nodeType int
@@ -150,6 +779,14 @@ const (
 )
 
 func (p *parser) readValue() (nodeType, error) {
+	if p.ctx != nil {
+		p.values++
+		if p.values%ctxCheckInterval == 0 {
+			if err := p.ctx.Err(); err != nil {
+				return nodeTypeInvalid, err
+			}
+		}
+	}
 	c, ok := p.next()
 	if !ok {
 		return nodeTypeInvalid, p.errorf(0, nil, EEmptyString)
@@ -162,33 +799,37 @@ func (p *parser) readValue() (nodeType, error) {
 		return nodeTypeObject, p.parseObject()
 	case c == '\'':
 		return nodeTypeString, p.parseQuotedString()
+	case c == '"' && p.AllowDoubleQuotes:
+		return nodeTypeString, p.parseDoubleQuotedString()
 	case c == '-' || '0' <= c && c <= '9':
 		return nodeTypeNumber, p.parseNumber()
 	}
 
 	p.index--
 
-	ok, err := p.parseID()
+	typ, err := p.parseID()
 	if err != nil {
 		return nodeTypeInvalid, err
 	}
-	if ok {
-		return nodeTypeString, nil
+	if typ != nodeTypeInvalid {
+		return typ, nil
 	}
 
 	return nodeTypeInvalid, p.errorf(0, nil, EInvalidCharacter, c)
 }
 
-func (p *parser) parseID() (bool, error) {
+// parseID parses an id, which is a string usually.
+// It returns nodeTypeInvalid if there is no id.
+func (p *parser) parseID() (nodeType, error) {
 	s := p.string
 	n := len(s)
 	i := p.index
 	if n <= i {
-		return false, nil
+		return nodeTypeInvalid, nil
 	}
 	c := s[i]
 	if 0 <= strings.IndexByte(notIDStart, c) {
-		return false, nil
+		return nodeTypeInvalid, nil
 	}
 	i++
 	id := []byte{c}
@@ -197,19 +838,74 @@ func (p *parser) parseID() (bool, error) {
 			break
 		}
 		c := s[i]
-		if 0 <= strings.IndexByte(notIDChar, c) {
+		if 0 <= strings.IndexByte(notIDChar, c) && !p.isKeyOperatorChar(c) {
 			break
 		}
 		i++
 		id = append(id, c)
+		if 0 < p.MaxStringLen && p.MaxStringLen < len(id) {
+			return nodeTypeInvalid, p.errorf(0, nil, EStringTooLong, p.MaxStringLen)
+		}
 	}
-	j, err := json.Marshal(string(id))
+	if v, ok := p.LiteralAliases[string(id)]; ok && !p.inKey {
+		typ, err := p.emitAlias(string(id), v, p.index, i)
+		if err != nil {
+			return nodeTypeInvalid, err
+		}
+		p.index = i
+		return typ, nil
+	}
+	err := p.emitString(id, p.index, i)
 	if err != nil {
-		return false, p.errorf(0, err, EInternal, fmt.Sprintf(`id "%s" cannot be converted to JSON`, string(id)))
+		return nodeTypeInvalid, err
 	}
 	p.index = i
-	p.buffer.Write(j)
-	return true, nil
+	return nodeTypeString, nil
+}
+
+// isKeyOperatorChar reports whether c is allowed in the object key
+// being parsed by KeyOperatorChars.
+func (p *parser) isKeyOperatorChar(c byte) bool {
+	return p.inKey && 0 <= strings.IndexByte(keyOperatorChars, c) && 0 <= strings.IndexByte(p.KeyOperatorChars, c)
+}
+
+// emitAlias passes the value of the alias of the id to the handler.
+func (p *parser) emitAlias(id string, v interface{}, start, end int) (nodeType, error) {
+	switch v := v.(type) {
+	case nil:
+		p.h.null(start, end)
+		return nodeTypeNull, nil
+	case bool:
+		p.h.boolean(v, start, end)
+		return nodeTypeBoolean, nil
+	case string:
+		p.h.string([]byte(v), start, end)
+		return nodeTypeString, nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil || j[0] == '"' || j[0] == '[' || j[0] == '{' || j[0] == 'n' {
+		return nodeTypeInvalid, p.errorf(0, err, EInternal, fmt.Sprintf(`alias "%s" must be null, a boolean, a number or a string`, id))
+	}
+	p.h.number(j, start, end)
+	return nodeTypeNumber, nil
+}
+
+// emitString passes the string to the handler as a key or a value.
+func (p *parser) emitString(s []byte, start, end int) error {
+	if 0 < p.MaxTotalStringBytes {
+		p.stringBytes += len(s)
+		if p.MaxTotalStringBytes < p.stringBytes {
+			return p.errorAt(start, nil, EStringBytesExceeded, p.MaxTotalStringBytes)
+		}
+	}
+	if p.inKey {
+		p.key = string(s)
+		p.keyStart = start
+		p.h.key(s, start, end)
+	} else {
+		p.h.string(s, start, end)
+	}
+	return nil
 }
 
 func (p *parser) parseSpecial() (nodeType, error) {
@@ -221,13 +917,13 @@ func (p *parser) parseSpecial() (nodeType, error) {
 	p.index++
 	switch c {
 	case 't':
-		p.buffer.WriteString("true")
+		p.h.boolean(true, p.index-2, p.index)
 		return nodeTypeBoolean, nil
 	case 'f':
-		p.buffer.WriteString("false")
+		p.h.boolean(false, p.index-2, p.index)
 		return nodeTypeBoolean, nil
 	case 'n':
-		p.buffer.WriteString("null")
+		p.h.null(p.index-2, p.index)
 		return nodeTypeNull, nil
 	case '(':
 		return nodeTypeArray, p.parseArray()
@@ -235,9 +931,30 @@ func (p *parser) parseSpecial() (nodeType, error) {
 	return nodeTypeInvalid, p.errorf(-1, nil, EInvalidLiteral, c)
 }
 
+// enter increments the nesting depth of arrays and objects
+// at the container starting at start.
+func (p *parser) enter(start int) error {
+	if 0 < p.MaxDepth && p.MaxDepth <= p.depth {
+		return p.errorAt(start, nil, EMaxDepthExceeded, p.MaxDepth)
+	}
+	p.depth++
+	return nil
+}
+
+// leave decrements the nesting depth of arrays and objects.
+func (p *parser) leave() {
+	p.depth--
+}
+
 func (p *parser) parseArray() error {
+	err := p.enter(p.index - 2)
+	if err != nil {
+		return err
+	}
+	defer p.leave()
 	notFirst := false
-	p.buffer.WriteByte('[')
+	n := 0
+	p.h.beginArray(p.index - 2)
 	for {
 		c, ok := p.next()
 		if !ok {
@@ -250,25 +967,35 @@ func (p *parser) parseArray() error {
 			if c != ',' {
 				return p.errorf(-1, nil, EMissingCharacter, ',')
 			}
-			p.buffer.WriteByte(',')
+			p.h.comma(p.index - 1)
 		} else if c == ',' {
 			return p.errorf(-1, nil, EExtraCharacter, ',')
 		} else {
 			p.index--
 		}
+		p.path = append(p.path, pathElem{index: n})
 		_, err := p.readValue()
 		if err != nil {
 			return err
 		}
+		p.path = p.path[:len(p.path)-1]
+		n++
 		notFirst = true
 	}
-	p.buffer.WriteByte(']')
+	p.h.endArray(p.index)
 	return nil
 }
 
 func (p *parser) parseObject() error {
+	err := p.enter(p.index - 1)
+	if err != nil {
+		return err
+	}
+	defer p.leave()
 	notFirst := false
-	p.buffer.WriteByte('{')
+	keys := 0
+	var seen map[string]bool
+	p.h.beginObject(p.index - 1)
 	for {
 		c, ok := p.next()
 		if !ok {
@@ -281,19 +1008,37 @@ func (p *parser) parseObject() error {
 			if c != ',' {
 				return p.errorf(-1, nil, EMissingCharacter, ',')
 			}
-			p.buffer.WriteByte(',')
+			p.h.comma(p.index - 1)
 		} else if c == ',' {
 			return p.errorf(-1, nil, EExtraCharacter, ',')
 		} else {
 			p.index--
 		}
+		if 0 < p.MaxKeys && p.MaxKeys <= keys {
+			return p.errorf(0, nil, EKeysExceeded, p.MaxKeys)
+		}
+		keys++
+		p.inKey = true
 		typ, err := p.readValue()
+		p.inKey = false
 		if err != nil {
 			return err
 		}
 		if typ != nodeTypeString {
 			return p.errorf(-1, nil, EInvalidTypeOfObjectKey)
 		}
+		if p.DisallowEmptyKeys && p.key == "" {
+			return p.errorf(-2, nil, EEmptyKey)
+		}
+		if p.DisallowDuplicateKeys {
+			if seen[p.key] {
+				return p.errorAt(p.keyStart, nil, EDuplicateKey, p.key)
+			}
+			if seen == nil {
+				seen = map[string]bool{}
+			}
+			seen[p.key] = true
+		}
 		c, ok = p.next()
 		if !ok {
 			return p.errorf(0, nil, EMissingCharacter, ':')
@@ -301,14 +1046,16 @@ func (p *parser) parseObject() error {
 		if c != ':' {
 			return p.errorf(-1, nil, EMissingCharacter, ':')
 		}
-		p.buffer.WriteByte(':')
+		p.h.colon(p.index - 1)
+		p.path = append(p.path, pathElem{key: p.key, index: -1})
 		_, err = p.readValue()
 		if err != nil {
 			return err
 		}
+		p.path = p.path[:len(p.path)-1]
 		notFirst = true
 	}
-	p.buffer.WriteByte('}')
+	p.h.endObject(p.index)
 	return nil
 }
 
@@ -327,6 +1074,9 @@ func (p *parser) parseQuotedString() error {
 		if c == '\'' {
 			break
 		}
+		if 0 < p.MaxStringLen && p.MaxStringLen < len(result)+i-start {
+			return p.errorf(-1, nil, EStringTooLong, p.MaxStringLen)
+		}
 		if c == '!' {
 			if start < i-1 {
 				result = append(result, s[start:i-1]...)
@@ -339,6 +1089,8 @@ func (p *parser) parseQuotedString() error {
 			i++
 			if c == '!' || c == '\'' {
 				result = append(result, c)
+			} else if p.LenientStringEscapes {
+				result = append(result, '!', c)
 			} else {
 				p.index = i
 				return p.errorf(0, nil, EInvalidStringEscape, c)
@@ -349,12 +1101,11 @@ func (p *parser) parseQuotedString() error {
 	if start < i-1 {
 		result = append(result, s[start:i-1]...)
 	}
-	p.index = i
-	j, err := json.Marshal(string(result))
+	err := p.emitString(result, p.index-1, i)
 	if err != nil {
-		return p.errorf(0, err, EInternal, fmt.Sprintf(`invalid string "%s"`, string(result)))
+		return err
 	}
-	p.buffer.Write(j)
+	p.index = i
 	return nil
 }
 
@@ -367,6 +1118,54 @@ const (
 	parseNumberStateExp
 )
 
+// parseDoubleQuotedString parses a string quoted with '"' by the JSON
+// rules, for AllowDoubleQuotes.
+func (p *parser) parseDoubleQuotedString() error {
+	s := p.string
+	i := p.index
+	for {
+		if len(s) <= i {
+			p.index = i
+			return p.errorf(0, nil, EUnmatchedPair, `"`)
+		}
+		c := s[i]
+		i++
+		if c == '"' {
+			break
+		}
+		if c < 0x20 {
+			p.index = i
+			return p.errorf(-1, nil, EInvalidCharacter, c)
+		}
+		if c == '\\' {
+			if len(s) <= i {
+				p.index = i
+				return p.errorf(0, nil, EMissingCharacterAfterEscape)
+			}
+			c = s[i]
+			i++
+			if strings.IndexByte(`"\/bfnrtu`, c) < 0 {
+				p.index = i
+				return p.errorf(-1, nil, EInvalidStringEscape, c)
+			}
+		}
+	}
+	var result string
+	err := json.Unmarshal(s[p.index-1:i], &result)
+	if err != nil {
+		return p.errorf(-1, err, EInvalidStringEscape, 'u')
+	}
+	if 0 < p.MaxStringLen && p.MaxStringLen < len(result) {
+		return p.errorf(-1, nil, EStringTooLong, p.MaxStringLen)
+	}
+	err = p.emitString([]byte(result), p.index-1, i)
+	if err != nil {
+		return err
+	}
+	p.index = i
+	return nil
+}
+
 func (p *parser) parseNumber() error {
 	s := p.string
 	i := p.index
@@ -391,16 +1190,16 @@ func (p *parser) parseNumber() error {
 		case parseNumberStateInt:
 			if c == '.' {
 				state = parseNumberStateFrac
-			} else if c == 'e' {
+			} else if c == 'e' || c == 'E' && p.AllowUppercaseExponent {
 				state = parseNumberStateExp
-				permittedSigns = []byte{'-'}
+				permittedSigns = p.exponentSigns()
 			} else {
 				state = parseNumberStateEnd
 			}
 		case parseNumberStateFrac:
-			if c == 'e' {
+			if c == 'e' || c == 'E' && p.AllowUppercaseExponent {
 				state = parseNumberStateExp
-				permittedSigns = []byte{'-'}
+				permittedSigns = p.exponentSigns()
 			} else {
 				state = parseNumberStateEnd
 			}
@@ -414,27 +1213,123 @@ func (p *parser) parseNumber() error {
 	if string(t) == "-" {
 		return p.errorf(0, nil, EInvalidNumber, "-")
 	}
+	if p.ReportLeadingZeros {
+		k := 0
+		if t[0] == '-' {
+			k = 1
+		}
+		if k+1 < len(t) && t[k] == '0' && '0' <= t[k+1] && t[k+1] <= '9' {
+			return p.errorAt(start+k, nil, ELeadingZero, string(t))
+		}
+	}
 	var result interface{}
 	err := json.Unmarshal(t, &result)
 	if err != nil {
 		return p.errorf(0, err, EInvalidNumber, string(t))
 	}
+	if p.RequireMinimalNumbers && !isLargeInteger(t) {
+		if m := minimalNumber(result.(float64)); m != string(t) {
+			return p.errorAt(start, nil, ENonMinimalNumber, string(t), m)
+		}
+	}
+	if p.OnPrecisionLoss != nil && !isExactInteger(t, result.(float64)) {
+		p.OnPrecisionLoss(t)
+	}
+	if p.ProtoJSONCompatible && isLargeInteger(t) {
+		p.h.string(t, start, i)
+		return nil
+	}
+	if p.UseNumber {
+		p.h.number(t, start, i)
+		return nil
+	}
 	j, err := json.Marshal(result)
 	if err != nil {
 		return p.errorf(0, err, EInvalidNumber, string(t))
 	}
-	p.buffer.Write(j)
+	p.h.number(j, start, i)
 	return nil
 }
 
+// isExactInteger reports whether f represents the number t exactly
+// when t is an integer without a fraction or an exponent.
+// It reports true for the other forms of numbers.
+func isExactInteger(t []byte, f float64) bool {
+	if 0 <= bytes.IndexAny(t, ".eE") {
+		return true
+	}
+	return new(big.Float).SetFloat64(f).Text('f', 0) == string(t)
+}
+
+// maxSafeInteger is the largest integer n where all the integers
+// in [-n, n] are represented exactly as float64.
+var maxSafeInteger = big.NewInt(1<<53 - 1)
+
+// isLargeInteger reports whether t is an integer without a fraction or
+// an exponent and out of the range where float64 is exact.
+func isLargeInteger(t []byte) bool {
+	if 0 <= bytes.IndexAny(t, ".eE") {
+		return false
+	}
+	n, ok := new(big.Int).SetString(string(t), 10)
+	return ok && 0 < n.CmpAbs(maxSafeInteger)
+}
+
+// exponentSigns returns the signs permitted at the start of exponents.
+func (p *parser) exponentSigns() []byte {
+	if p.AllowPlusExponent {
+		return []byte{'-', '+'}
+	}
+	return []byte{'-'}
+}
+
+// minimalNumber returns the shortest form of f as Marshal writes it.
+func minimalNumber(f float64) string {
+	j, _ := json.Marshal(f)
+	return strings.Replace(string(j), "+", "", -1)
+}
+
 // return the next non-whitespace character
 func (p *parser) next() (byte, bool) {
 	for p.index < len(p.string) {
 		c := p.string[p.index]
 		p.index++
-		if !p.SkipWhitespaces || strings.IndexByte(parserWhitespace, c) < 0 {
+		if p.AllowComments && p.skipComment(c) {
+			continue
+		}
+		if !(p.SkipWhitespaces || p.AllowComments) || strings.IndexByte(parserWhitespace, c) < 0 {
 			return c, true
 		}
 	}
 	return 0, false
 }
+
+// skipComment skips the comment starting with c if any.
+func (p *parser) skipComment(c byte) bool {
+	end := len(p.string)
+	if p.Mode != Rison {
+		end-- // the comment must not hide the parenthesis wrapping the input
+	}
+	s := p.string[:end]
+	i := p.index
+	switch {
+	case c == '#' || c == '/' && i < len(s) && s[i] == '/':
+		n := bytes.IndexByte(s[i:], '\n')
+		if n < 0 {
+			p.index = len(s)
+		} else {
+			p.index = i + n + 1
+		}
+	case c == '/' && i < len(s) && s[i] == '*':
+		n := bytes.Index(s[i+1:], []byte("*/"))
+		if n < 0 {
+			p.openComment = i - 1
+			p.index = len(s)
+		} else {
+			p.index = i + 1 + n + 2
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -38,6 +38,16 @@ func ExampleUnmarshal() {
 	// Output: {I:1 F:2.3 S:str B:true P:<nil> A:[7 8 9] X:map[y:Y]}
 }
 
+func ExampleDecodeTyped() {
+	r := "(i:1,f:2.3,s:str,b:!t,p:!n,a:!(7,8,9),x:(y:Y))"
+	v, err := rison.DecodeTyped[exampleStruct]([]byte(r), rison.Rison)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%+v\n", v)
+	// Output: {I:1 F:2.3 S:str B:true P:<nil> A:[7 8 9] X:map[y:Y]}
+}
+
 func ExampleToJSON() {
 	r := "!(1,2.3,str,'ing',true,nil,(a:b),!(7,8,9))"
 	j, _ := rison.ToJSON([]byte(r), rison.Rison)
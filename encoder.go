@@ -2,29 +2,266 @@ package rison
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// EncodeOptions holds the options to change the behavior of the encoder.
+// The zero value encodes in the same way as Marshal.
+type EncodeOptions struct {
+	// SortArrays makes the encoder sort the elements of arrays in which
+	// all the elements are numbers, all are strings, or all are booleans,
+	// for the canonical encoding of arrays used as sets, where
+	// !(3,1,2) and !(1,2,3) are the same value. Arrays with elements of
+	// mixed types, or holding arrays or objects, are left unsorted
+	// (the arrays and objects in them are still encoded with the options).
+	SortArrays bool
+
+	// MaxDepth, if positive, is the maximum nesting depth of arrays and
+	// objects. The encoder fails on a value nested deeper, such as an
+	// accidentally recursive structure, instead of recursing further.
+	// The top-level array or object is at depth 1.
+	MaxDepth int
+
+	// ZeroTimeAsNull makes the encoder write !n for the zero value of
+	// time.Time, instead of the string "0001-01-01T00:00:00Z".
+	// Since the null is decoded into a time.Time as the zero value,
+	// the value is kept through the round trip.
+	ZeroTimeAsNull bool
+
+	// UseStringer makes the encoder write the values implementing
+	// fmt.Stringer but neither json.Marshaler nor encoding.TextMarshaler
+	// as the strings returned by their String methods, instead of by
+	// their kinds; time.Duration is written as '1m30s', not 90000000000.
+	// Such strings cannot be decoded back into the values unless they
+	// implement UnmarshalJSON or UnmarshalText. Note that *regexp.Regexp
+	// implements both MarshalText and UnmarshalText since Go 1.21, so it
+	// round-trips as its pattern even without the option.
+	UseStringer bool
+
+	// KeyPriority lists the object keys written first, in the order of
+	// the list, such as []string{"type"} for (type:range,a:1,b:2).
+	// The other keys follow in the sorted order as usual. It applies
+	// to both the maps and the structs.
+	KeyPriority []string
+
+	// TimeFormat is the format of the time.Time values.
+	// The default is TimeRFC3339, as "encoding/json" does.
+	TimeFormat TimeFormat
+
+	// AllowedKeys, if set, is called with each key of the maps, and the
+	// encoder fails on the keys for which it returns false, so that
+	// unexpected keys of dynamic maps never leak into the output.
+	// The struct fields are not checked.
+	AllowedKeys func(key string) bool
+
+	// AllowIterators makes the encoder write the functions of the forms
+	// of iter.Seq[V] as arrays and iter.Seq2[K, V] as objects, with the
+	// keys K of the same types as the map keys. The elements of an
+	// iter.Seq are written as they are yielded, without making a slice;
+	// the members of an iter.Seq2 are sorted as those of a map, so its
+	// values are kept until the end. Other functions are not encodable.
+	AllowIterators bool
+
+	// KeepFieldOrder makes the encoder write the fields of structs in
+	// the order of the declaration instead of sorting the keys, such as
+	// (name:x,min:1,max:9) grouped by the author for readability. The
+	// keys in KeyPriority are still written first, and the members in
+	// the rest field follow the fields in the sorted order. The keys of
+	// maps are always sorted, as their order is not kept.
+	KeepFieldOrder bool
+
+	// BareStringPredicate, if not nil, reports whether the string, a
+	// value or an object key, may be written without quotes, such as
+	// rejecting "domain.com" to write 'domain.com' for a downstream
+	// parser which splits by ".". It can only add quoting: the strings
+	// which cannot be bare in Rison, such as "1" or "a b", are always
+	// quoted, whatever it reports.
+	BareStringPredicate func(string) bool
+}
+
 // Marshal returns the Rison encoding of v.
 //
 // The object keys corresponding the struct fields can be
 // specified in struct tag (not "rison" but) "json".
+// The values are encoded in the same way as "encoding/json",
+// including the ones implementing json.Marshaler and
+// encoding.TextMarshaler.
+//
+// The output is canonical: the object keys are sorted at every level
+// of nesting, including objects inside arrays, and numbers and strings
+// are written in their shortest forms. So a tree returned by Decode
+// can be modified and encoded back to the canonical Rison with Marshal.
+//
+// The numbers are formatted independently of the locale, in the same
+// way as "encoding/json" except that "+" is removed from exponents.
+// Integer types are written in decimal digits. Floating-point numbers
+// are written in the shortest form representing the same value: in
+// decimal notation such as 100000000000000000000 and 0.000001 if the
+// absolute value is in [1e-6, 1e21), and in exponent notation such as
+// 1e21 and 1e-7 otherwise.
 func Marshal(v interface{}, m Mode) ([]byte, error) {
-	j, err := json.Marshal(v)
+	return MarshalWithOptions(v, m, EncodeOptions{})
+}
+
+// MarshalWithOptions is like Marshal but encodes with the options.
+func MarshalWithOptions(v interface{}, m Mode, opts EncodeOptions) ([]byte, error) {
+	return (&encoder{Mode: m, EncodeOptions: opts}).marshal(v)
+}
+
+// maxPooledBuffer is the capacity of the largest buffer kept for reuse
+// by EncodeTo, not to hold the memory for a rare large value.
+const maxPooledBuffer = 64 << 10
+
+var encodeBuffers = sync.Pool{
+	New: func() interface{} { return &bytes.Buffer{} },
+}
+
+// EncodeTo appends the Rison encoding of v to dst and returns the
+// extended slice, as Marshal encodes it. The buffers to encode the
+// values into are pooled and reused across the calls, so that encoding
+// into a reused dst, such as dst[:0], does not allocate the output
+// buffers; the reflection walk still allocates for the maps and the
+// slices it visits. For a tree of about 4 KB of output, it saves about
+// 40% of the bytes allocated by Marshal, with a similar number of
+// allocations. On an error, dst is returned as it is. It is safe to call from
+// multiple goroutines, with different dst.
+func EncodeTo(dst []byte, v interface{}, m Mode) ([]byte, error) {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			encodeBuffers.Put(buf)
+		}
+	}()
+	r, err := (&encoder{Mode: m, buffer: buf}).encodeToBuffer(v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, r...), nil
+}
+
+// MarshalIndent is like Marshal but writes each element of the arrays
+// and each member of the objects in a new line beginning with prefix
+// followed by one or more copies of indent according to the nesting,
+// as json.MarshalIndent does. The empty arrays and objects are kept in
+// a line. The result is decoded with SkipWhitespaces of DecodeOptions
+// if prefix and indent consist of whitespaces.
+func MarshalIndent(v interface{}, m Mode, prefix, indent string) ([]byte, error) {
+	r, err := Marshal(v, m)
 	if err != nil {
 		return nil, err
 	}
-	return FromJSON(j, m)
+	return indentRison(r, prefix, indent), nil
+}
+
+// indentRison inserts the newlines and the indentation into the
+// Rison-encoded data r written by the encoder.
+func indentRison(r []byte, prefix, indent string) []byte {
+	var b bytes.Buffer
+	depth := 0
+	newline := func() {
+		b.WriteByte('\n')
+		b.WriteString(prefix)
+		for i := 0; i < depth; i++ {
+			b.WriteString(indent)
+		}
+	}
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch c {
+		case '\'':
+			// copy the quoted string, where "!" escapes the next character
+			j := i + 1
+			for ; j < len(r) && r[j] != '\''; j++ {
+				if r[j] == '!' {
+					j++
+				}
+			}
+			b.Write(r[i : j+1])
+			i = j
+			continue
+		case '!':
+			if i+1 < len(r) && r[i+1] == '(' {
+				b.WriteByte('!')
+				i++
+				c = '('
+			}
+		}
+		switch c {
+		case '(':
+			b.WriteByte('(')
+			if i+1 < len(r) && r[i+1] == ')' {
+				b.WriteByte(')')
+				i++
+				continue
+			}
+			depth++
+			newline()
+		case ')':
+			depth--
+			newline()
+			b.WriteByte(')')
+		case ',':
+			b.WriteByte(',')
+			newline()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.Bytes()
+}
+
+// EncodeTyped is the same as Marshal but takes a value of type T,
+// for the symmetry with DecodeTyped in generic code.
+func EncodeTyped[T any](v T, m Mode) ([]byte, error) {
+	return Marshal(v, m)
+}
+
+// Report is the statistics of the encoding by MarshalWithReport,
+// to find out why the output is long.
+type Report struct {
+	// QuotedStrings is the number of the strings, including object keys,
+	// quoted because they cannot be written as ids.
+	QuotedStrings int
+
+	// Escapes is the number of "!" inserted to escape "'" and "!"
+	// in the quoted strings.
+	Escapes int
+
+	// LargestPath is the path of the largest scalar value in the output,
+	// such as ".items[2].name", and LargestLen is its length in bytes.
+	LargestPath string
+	LargestLen  int
+}
+
+// MarshalWithReport is like Marshal but also returns the statistics
+// of the encoding.
+func MarshalWithReport(v interface{}, m Mode) ([]byte, Report, error) {
+	var report Report
+	r, err := (&encoder{Mode: m, report: &report}).marshal(v)
+	return r, report, err
 }
 
 // FromJSON parses the JSON-encoded data and returns the
 // Rison-encoded data that expresses the equal value.
 func FromJSON(data []byte, m Mode) ([]byte, error) {
-	return (&encoder{Mode: m}).encode(data)
+	return FromJSONWithOptions(data, m, EncodeOptions{})
+}
+
+// FromJSONWithOptions is like FromJSON but encodes with the options.
+func FromJSONWithOptions(data []byte, m Mode, opts EncodeOptions) ([]byte, error) {
+	return (&encoder{Mode: m, EncodeOptions: opts}).encode(data)
 }
 
 // Encode is an alias of Marshal.
@@ -32,11 +269,234 @@ func Encode(v interface{}, m Mode) ([]byte, error) {
 	return Marshal(v, m)
 }
 
+// EncodeTree returns the Rison encoding of the tree v, which holds only
+// the values returned by Decode: map[string]interface{}, []interface{},
+// float64, json.Number, string, bool and nil, and also the values
+// implementing Marshaler, and encoding.TextMarshaler written as
+// strings. It is a fast
+// path of Marshal for the trees, walking them without reflection.
+func EncodeTree(v interface{}, m Mode) ([]byte, error) {
+	e := &encoder{Mode: m, buffer: bytes.NewBuffer([]byte{})}
+	err := e.encodeTree(v)
+	if err != nil {
+		return nil, err
+	}
+	r := e.buffer.Bytes()
+	switch {
+	case m == ORison && !bytes.HasPrefix(r, []byte("(")):
+		return nil, checkKindMatchesMode(reflect.Invalid, m)
+	case m == ARison && !bytes.HasPrefix(r, []byte("!(")):
+		return nil, checkKindMatchesMode(reflect.Invalid, m)
+	}
+	return convertRisonToMode(r, m)
+}
+
+// isNilPtr reports whether v is a nil pointer, whose methods of value
+// receivers cannot be called.
+func isNilPtr(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func (e *encoder) encodeTree(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.buffer.WriteString("!n")
+	case bool:
+		if v {
+			e.buffer.WriteString("!t")
+		} else {
+			e.buffer.WriteString("!f")
+		}
+	case float64:
+		return e.encodeNumber(reflect.ValueOf(v))
+	case json.Number:
+		return e.encodeJSONNumber(reflect.ValueOf(v))
+	case string:
+		e.writeString(v)
+	case Marshaler:
+		if isNilPtr(v) {
+			e.buffer.WriteString("!n")
+			break
+		}
+		r, err := v.MarshalRison()
+		if err == nil {
+			err = (&parser{Mode: Rison}).walk(r, discard{})
+		}
+		if err != nil {
+			return fmt.Errorf("non-encodable %T value at %s in a tree: %s", v, e.pathString(), err.Error())
+		}
+		e.buffer.Write(r)
+	case encoding.TextMarshaler:
+		if isNilPtr(v) {
+			e.buffer.WriteString("!n")
+			break
+		}
+		t, err := v.MarshalText()
+		if err != nil {
+			return fmt.Errorf("non-encodable %T value at %s in a tree: %s", v, e.pathString(), err.Error())
+		}
+		e.writeString(string(t))
+	case []interface{}:
+		e.buffer.WriteString("!(")
+		for i, elem := range v {
+			if 0 < i {
+				e.buffer.WriteByte(',')
+			}
+			e.pushIndex(i)
+			err := e.encodeTree(elem)
+			e.pop()
+			if err != nil {
+				return err
+			}
+		}
+		e.buffer.WriteByte(')')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.buffer.WriteByte('(')
+		for i, k := range keys {
+			if 0 < i {
+				e.buffer.WriteByte(',')
+			}
+			e.writeString(k)
+			e.buffer.WriteByte(':')
+			e.pushKey(k)
+			err := e.encodeTree(v[k])
+			e.pop()
+			if err != nil {
+				return err
+			}
+		}
+		e.buffer.WriteByte(')')
+	default:
+		return fmt.Errorf("non-encodable %T value at %s in a tree", v, e.pathString())
+	}
+	return nil
+}
+
+// MarshalShortest returns the shortest Rison encoding of v among the
+// modes that can express it, and the mode to decode it with.
+// An object is encoded in the O-Rison and an array in the A-Rison,
+// which are shorter than the Rison by the parentheses wrapping them.
+func MarshalShortest(v interface{}) ([]byte, Mode, error) {
+	r, err := Marshal(v, Rison)
+	if err != nil {
+		return nil, Rison, err
+	}
+	shortest, mode := r, Rison
+	for _, m := range []Mode{ORison, ARison} {
+		c, err := ConvertMode(r, Rison, m)
+		if err == nil && len(c) < len(shortest) {
+			shortest, mode = c, m
+		}
+	}
+	return shortest, mode, nil
+}
+
+// NestedRison returns a wrapper of v which is encoded not as a nested
+// value but as a string holding the Rison encoding of v in the mode m.
+//
+// For example, a field holding NestedRison(map[string]string{"type": "x"}, Rison)
+// is encoded as (filter:'(type:x)'), and its value can be decoded again
+// from the string.
+func NestedRison(v interface{}, m Mode) json.Marshaler {
+	return &nestedRison{v: v, mode: m}
+}
+
+type nestedRison struct {
+	v    interface{}
+	mode Mode
+}
+
+func (n *nestedRison) MarshalJSON() ([]byte, error) {
+	return MarshalJSONString(n.v, n.mode)
+}
+
+// MarshalJSONString returns the Rison encoding of v in the mode m as
+// a quoted JSON string, ready to be embedded in a JSON document such as
+// {"q":"(a:'x y')"}. The characters "<", ">" and "&" are escaped as in
+// json.Marshal.
+func MarshalJSONString(v interface{}, m Mode) ([]byte, error) {
+	r, err := Marshal(v, m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(r))
+}
+
 type encoder struct {
-	Mode   Mode
+	Mode Mode
+	EncodeOptions
 	buffer *bytes.Buffer
+	report *Report
+	depth  int
+
+	// the pointers being encoded, to detect cycles in deep nesting
+	ptrLevel uint
+	ptrSeen  map[interface{}]struct{}
+
+	// the ranks of the keys in KeyPriority
+	priority map[string]int
+
+	// the path to the value being encoded, for the errors
+	path []pathElem
+
+	sorter entrySorter
+}
+
+func (e *encoder) pushKey(key string) {
+	e.path = append(e.path, pathElem{key: key, index: -1})
+}
+
+func (e *encoder) pushIndex(i int) {
+	e.path = append(e.path, pathElem{index: i})
+}
+
+func (e *encoder) pop() {
+	e.path = e.path[:len(e.path)-1]
+}
+
+// pathString returns the path to the value being encoded, such as
+// .items[1].name, or "." for the top-level value.
+func (e *encoder) pathString() string {
+	return formatPath(e.path)
+}
+
+// rank returns the index of the object key k in KeyPriority.
+func (e *encoder) rank(k string) (int, bool) {
+	if e.priority == nil && 0 < len(e.KeyPriority) {
+		e.priority = make(map[string]int, len(e.KeyPriority))
+		for i, k := range e.KeyPriority {
+			if _, ok := e.priority[k]; !ok {
+				e.priority[k] = i
+			}
+		}
+	}
+	r, ok := e.priority[k]
+	return r, ok
+}
+
+// keyLess reports whether the object key a is written before b.
+func (e *encoder) keyLess(a, b string) bool {
+	ra, oka := e.rank(a)
+	rb, okb := e.rank(b)
+	switch {
+	case oka && okb:
+		return ra < rb
+	case oka || okb:
+		return oka
+	}
+	return a < b
 }
 
+// startDetectingCyclesAfter is the nesting level of pointers, maps and
+// slices from where the encoder starts detecting cycles, as "encoding/json".
+const startDetectingCyclesAfter = 1000
+
 func checkKindMatchesMode(kind reflect.Kind, mode Mode) error {
 	switch mode {
 	case ORison:
@@ -51,6 +511,41 @@ func checkKindMatchesMode(kind reflect.Kind, mode Mode) error {
 	return nil
 }
 
+// ConvertMode converts the data encoded in the mode from
+// to the encoding of the same value in the mode to.
+//
+// The data is validated but not decoded; only the parentheses wrapping
+// the whole value are added or stripped. It fails if the value cannot be
+// expressed in the mode to, such as an array in the O-Rison.
+func ConvertMode(data []byte, from, to Mode) ([]byte, error) {
+	err := (&parser{Mode: from}).walk(data, discard{})
+	if err != nil {
+		return nil, err
+	}
+	r := make([]byte, 0, len(data)+3)
+	switch from {
+	case ORison:
+		r = append(append(append(r, '('), data...), ')')
+	case ARison:
+		r = append(append(append(r, '!', '('), data...), ')')
+	default:
+		r = append(r, data...)
+	}
+	switch to {
+	case ORison:
+		if !bytes.HasPrefix(r, []byte("(")) {
+			return nil, fmt.Errorf("only an object can be converted to the O-Rison")
+		}
+		r = r[1 : len(r)-1]
+	case ARison:
+		if !bytes.HasPrefix(r, []byte("!(")) {
+			return nil, fmt.Errorf("only an array can be converted to the A-Rison")
+		}
+		r = r[2 : len(r)-1]
+	}
+	return r, nil
+}
+
 func convertRisonToMode(r []byte, mode Mode) ([]byte, error) {
 	n := len(r)
 	switch mode {
@@ -89,7 +584,7 @@ func (e *encoder) encode(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("invalid JSON: %s", string(data))
 	}
 
-	err = e.encodeValue("", vv)
+	err = e.encodeValue(vv)
 	if err != nil {
 		return nil, err
 	}
@@ -99,6 +594,76 @@ func (e *encoder) encode(data []byte) ([]byte, error) {
 	return convertRisonToMode(r, e.Mode)
 }
 
+func (e *encoder) marshal(v interface{}) ([]byte, error) {
+	e.buffer = bytes.NewBuffer([]byte{})
+	return e.encodeToBuffer(v)
+}
+
+// encodeToBuffer writes v into e.buffer, which the result is a part of.
+func (e *encoder) encodeToBuffer(v interface{}) ([]byte, error) {
+	err := e.encodeValue(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	r := e.buffer.Bytes()
+	e.buffer = nil
+	switch {
+	case e.Mode == ORison && !bytes.HasPrefix(r, []byte("(")):
+		return nil, checkKindMatchesMode(reflect.Invalid, e.Mode)
+	case e.Mode == ARison && !bytes.HasPrefix(r, []byte("!(")):
+		return nil, checkKindMatchesMode(reflect.Invalid, e.Mode)
+	}
+	return convertRisonToMode(r, e.Mode)
+}
+
+// NeedsQuoting reports whether the string s is quoted when encoded in Rison.
+// The strings which look like numbers or literals, such as "1", "-x" and
+// "!t", are always quoted, since the bare ones cannot start with a digit
+// or "-" and cannot contain "!".
+func NeedsQuoting(s string) bool {
+	if !utf8.ValidString(s) {
+		s = toValidUTF8(s)
+	}
+	return !idOk(s)
+}
+
+// IsBareString reports whether the string s can be written in Rison
+// without quotes, as Marshal writes it: it is not empty, is valid
+// UTF-8, and consists of the characters accepted by IsBareStringChar.
+// Unlike NeedsQuoting, the invalid UTF-8 strings are never bare, since
+// they are not written as they are.
+func IsBareString(s string) bool {
+	return utf8.ValidString(s) && idOk(s)
+}
+
+// IsBareStringChar reports whether the byte c can appear in a string
+// written without quotes, at the first byte of it if first is true.
+// The bytes of the multibyte UTF-8 characters are all accepted.
+// The first byte cannot be a digit or "-", to be told from the numbers,
+// and no bytes can be a space or any of '!:(),*@$ anywhere.
+func IsBareStringChar(c byte, first bool) bool {
+	notID := notIDChar
+	if first {
+		notID = notIDStart
+	}
+	return strings.IndexByte(notID, c) < 0
+}
+
+// EscapeForString escapes "!" and "'" in the Rison-encoded data, and
+// returns the content of a quoted string whose value is the data,
+// for embedding a Rison document in another one by hand such as
+// (sub:'<escaped>'). The quotes are not added.
+func EscapeForString(rison []byte) []byte {
+	b := make([]byte, 0, len(rison))
+	for _, c := range rison {
+		if c == '\'' || c == '!' {
+			b = append(b, '!')
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
 func idOk(s string) bool {
 	n := len(s)
 	if n == 0 {
@@ -115,40 +680,46 @@ func idOk(s string) bool {
 	return true
 }
 
-func (e *encoder) writeString(v reflect.Value) bool {
-	if !v.CanInterface() {
-		return false
-	}
-	s, ok := v.Interface().(string)
-	if !ok {
-		return false
+func (e *encoder) writeString(s string) {
+	if !utf8.ValidString(s) {
+		s = toValidUTF8(s)
 	}
-	if idOk(s) {
+	if idOk(s) && (e.BareStringPredicate == nil || e.BareStringPredicate(s)) {
 		e.buffer.WriteString(s)
-		return true
+		return
 	}
 	n := len(s)
+	if e.report != nil {
+		e.report.QuotedStrings++
+	}
 	e.buffer.WriteByte('\'')
 	for i := 0; i < n; i++ {
 		c := s[i]
 		if c == '\'' || c == '!' {
 			e.buffer.WriteByte('!')
+			if e.report != nil {
+				e.report.Escapes++
+			}
 		}
 		e.buffer.WriteByte(c)
 	}
 	e.buffer.WriteByte('\'')
-	return true
 }
 
-func (e *encoder) encodeBool(path string, v reflect.Value) error {
-	if !v.CanInterface() {
-		return fmt.Errorf("internal error")
-	}
-	b, ok := v.Interface().(bool)
-	if !ok {
-		return fmt.Errorf("internal error")
+// toValidUTF8 replaces each invalid byte in s with U+FFFD,
+// as "encoding/json" does.
+func toValidUTF8(s string) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		b = utf8.AppendRune(b, r)
+		i += size
 	}
-	if b {
+	return string(b)
+}
+
+func (e *encoder) encodeBool(v reflect.Value) error {
+	if v.Bool() {
 		e.buffer.WriteString("!t")
 	} else {
 		e.buffer.WriteString("!f")
@@ -156,48 +727,384 @@ func (e *encoder) encodeBool(path string, v reflect.Value) error {
 	return nil
 }
 
-func (e *encoder) encodeNumber(path string, v reflect.Value) error {
-	if !v.CanInterface() {
-		return fmt.Errorf("internal error")
+func (e *encoder) encodeNumber(v reflect.Value) error {
+	var a [32]byte
+	var j []byte
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		j = strconv.AppendInt(a[:0], v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		j = strconv.AppendUint(a[:0], v.Uint(), 10)
+	default:
+		f := v.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			_, err := json.Marshal(f) // the same error as "encoding/json"
+			return err
+		}
+		bits := 64
+		if v.Kind() == reflect.Float32 {
+			bits = 32
+		}
+		j = appendFloat(a[:0], f, bits)
 	}
-	j, err := json.Marshal(v.Interface())
+	e.buffer.Write(j)
+	return nil
+}
+
+// appendFloat appends the finite number f of the bits in the same form
+// as "encoding/json" writes it, except that "+" is removed from the
+// exponent.
+func appendFloat(b []byte, f float64, bits int) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || 1e21 <= abs) || bits == 32 && (float32(abs) < 1e-6 || 1e21 <= float32(abs)) {
+			format = 'e'
+		}
+	}
+	start := len(b)
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// 1e+21 to 1e21, and 1e-07 to 1e-7
+		i := bytes.LastIndexByte(b[start:], 'e') + start + 1
+		if b[i] == '+' {
+			b = append(b[:i], b[i+1:]...)
+		} else {
+			i++
+		}
+		if i+2 == len(b) && b[i] == '0' {
+			b = append(b[:i], b[i+1])
+		}
+	}
+	return b
+}
+
+var (
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+func (e *encoder) encodeJSONNumber(v reflect.Value) error {
+	j, err := json.Marshal(json.Number(v.String()))
 	if err != nil {
 		return err
 	}
 	j = bytes.Replace(j, []byte{'+'}, []byte{}, -1)
+	j = bytes.Replace(j, []byte{'E'}, []byte{'e'}, -1)
 	e.buffer.Write(j)
 	return nil
 }
 
-func (e *encoder) encodeMap(path string, v reflect.Value) error {
-	e.buffer.WriteByte('(')
-	keys := v.MapKeys()
-	sort.Slice(keys, func(i, j int) bool {
-		if !keys[i].CanInterface() {
-			return false
-		}
-		ki, ok := keys[i].Interface().(string)
-		if !ok {
-			return false
+// encodeJSON encodes the JSON-encoded data j returned by MarshalJSON.
+func (e *encoder) encodeJSON(j []byte) error {
+	var v interface{}
+	err := json.Unmarshal(j, &v)
+	if err != nil {
+		return err
+	}
+	return e.encodeValue(reflect.ValueOf(v))
+}
+
+// isZeroTime reports whether v is (a pointer to) the zero value of time.Time.
+func isZeroTime(v reflect.Value) bool {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v.Type() == timeType && v.CanInterface() && v.Interface().(time.Time).IsZero()
+}
+
+// enter increments the nesting depth of arrays and objects.
+func (e *encoder) enter() error {
+	if 0 < e.MaxDepth && e.MaxDepth <= e.depth {
+		return fmt.Errorf("nesting depth exceeds %d at %s", e.MaxDepth, e.pathString())
+	}
+	e.depth++
+	return nil
+}
+
+func (e *encoder) leave() {
+	e.depth--
+}
+
+// enterPtr increments the nesting level of the pointer, map or slice v
+// and reports an error if v is being encoded already.
+func (e *encoder) enterPtr(v reflect.Value) error {
+	e.ptrLevel++
+	if e.ptrLevel <= startDetectingCyclesAfter {
+		return nil
+	}
+	if e.ptrSeen == nil {
+		e.ptrSeen = map[interface{}]struct{}{}
+	}
+	p := ptrKey(v)
+	if _, ok := e.ptrSeen[p]; ok {
+		return fmt.Errorf("encountered a cycle")
+	}
+	e.ptrSeen[p] = struct{}{}
+	return nil
+}
+
+func (e *encoder) leavePtr(v reflect.Value) {
+	if e.ptrLevel > startDetectingCyclesAfter {
+		delete(e.ptrSeen, ptrKey(v))
+	}
+	e.ptrLevel--
+}
+
+// ptrKey returns the key to detect cycles through the pointer, map or slice v.
+func ptrKey(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Slice {
+		return struct {
+			ptr uintptr
+			len int
+		}{v.Pointer(), v.Len()}
+	}
+	return v.Pointer()
+}
+
+// mapKey returns the object key for the map key k, as "encoding/json".
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", nil
 		}
-		if !keys[j].CanInterface() {
-			return true
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		// as "encoding/json" writes the float keys, such as "1e+21"
+		var b []byte
+		var err error
+		if k.Kind() == reflect.Float32 {
+			b, err = json.Marshal(float32(k.Float()))
+		} else {
+			b, err = json.Marshal(k.Float())
 		}
-		kj, ok := keys[j].Interface().(string)
-		if !ok {
-			return true
+		return string(b), err
+	}
+	return "", fmt.Errorf(`invalid key %+v`, k)
+}
+
+func (e *encoder) encodeMap(v reflect.Value) error {
+	if v.IsNil() {
+		e.buffer.WriteString("!n")
+		return nil
+	}
+	err := e.enterPtr(v)
+	if err != nil {
+		return err
+	}
+	defer e.leavePtr(v)
+
+	// the keys and the values are copied into the reused key and
+	// a slice of the values, not to allocate each of them
+	n := v.Len()
+	entries := make([]mapEntry, 0, n)
+	values := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), n, n)
+	k := reflect.New(v.Type().Key()).Elem()
+	iter := v.MapRange()
+	for i := 0; iter.Next() && i < n; i++ {
+		k.SetIterKey(iter)
+		values.Index(i).SetIterValue(iter)
+		en, err := e.mapEntry(k, values.Index(i))
+		if err != nil {
+			return err
 		}
-		return ki < kj
-	})
-	for i, k := range keys {
+		entries = append(entries, en)
+	}
+	return e.encodeEntries(entries)
+}
+
+// mapEntry is a member of a map or an iter.Seq2 to be encoded.
+type mapEntry struct {
+	key   string
+	value reflect.Value
+}
+
+// entrySorter sorts the entries by keyLess. It is kept in the encoder
+// to be passed to sort.Sort without allocating.
+type entrySorter struct {
+	e       *encoder
+	entries []mapEntry
+}
+
+func (s *entrySorter) Len() int {
+	return len(s.entries)
+}
+
+func (s *entrySorter) Less(i, j int) bool {
+	return s.e.keyLess(s.entries[i].key, s.entries[j].key)
+}
+
+func (s *entrySorter) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+
+// mapEntry returns the entry of the key k and the value v in the map at
+// the path, checking the key with AllowedKeys.
+func (e *encoder) mapEntry(k, v reflect.Value) (mapEntry, error) {
+	key, err := mapKey(k)
+	if err != nil {
+		return mapEntry{}, err
+	}
+	err = e.checkKey(key)
+	if err != nil {
+		return mapEntry{}, err
+	}
+	return mapEntry{key, v}, nil
+}
+
+// checkKey reports an error if the key of a map or the rest field
+// at the path is rejected by AllowedKeys.
+func (e *encoder) checkKey(key string) error {
+	if e.AllowedKeys != nil && !e.AllowedKeys(key) {
+		return fmt.Errorf("key %q is not allowed at %s", key, e.pathString())
+	}
+	return nil
+}
+
+// encodeEntries writes the entries as an object in the sorted order.
+func (e *encoder) encodeEntries(entries []mapEntry) error {
+	e.sorter = entrySorter{e, entries}
+	sort.Sort(&e.sorter)
+	e.sorter = entrySorter{}
+
+	e.buffer.WriteByte('(')
+	for i, en := range entries {
 		if 0 < i {
 			e.buffer.WriteByte(',')
 		}
-		if !e.writeString(k) {
-			return fmt.Errorf(`invalid key %+v`, k)
+		e.writeString(en.key)
+		e.buffer.WriteByte(':')
+		e.pushKey(en.key)
+		err := e.encodeValue(en.value)
+		e.pop()
+		if err != nil {
+			return err
+		}
+	}
+	e.buffer.WriteByte(')')
+	return nil
+}
+
+// isEmptyValue reports whether v is empty for the "omitempty" option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// zeroer is the interface of the types telling whether they are zero
+// for the "omitzero" option, such as time.Time.
+type zeroer interface {
+	IsZero() bool
+}
+
+var zeroerType = reflect.TypeOf((*zeroer)(nil)).Elem()
+
+// isZeroValue reports whether v is zero for the "omitzero" option, by
+// its IsZero method if any, in the same way as "encoding/json".
+func isZeroValue(v reflect.Value) bool {
+	t := v.Type()
+	switch {
+	case (t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface) && t.Implements(zeroerType):
+		return v.IsNil() || v.Interface().(zeroer).IsZero()
+	case t.Implements(zeroerType):
+		return v.Interface().(zeroer).IsZero()
+	case reflect.PtrTo(t).Implements(zeroerType):
+		if !v.CanAddr() {
+			c := reflect.New(t).Elem()
+			c.Set(v)
+			v = c
+		}
+		return v.Addr().Interface().(zeroer).IsZero()
+	}
+	return v.IsZero()
+}
+
+// fieldByIndex returns the field of the struct v by the index.
+// It reports false if the field is in a nil embedded struct pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+	rest := restMap(v)
+	for k := range rest {
+		err := e.checkKey(k)
+		if err != nil {
+			return err
+		}
+		if !hasField(fields, k) {
+			fields = append(fields, structField{name: k, rest: true})
+		}
+	}
+	if e.KeepFieldOrder {
+		sort.SliceStable(fields, func(i, j int) bool {
+			return e.fieldLess(fields[i], fields[j])
+		})
+	} else {
+		sort.Slice(fields, func(i, j int) bool {
+			return e.keyLess(fields[i].name, fields[j].name)
+		})
+	}
+	e.buffer.WriteByte('(')
+	n := 0
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if f.rest {
+			fv = reflect.ValueOf(rest[f.name])
+		} else if !ok || f.omitEmpty && isEmptyValue(fv) || f.omitZero && isZeroValue(fv) {
+			continue
+		}
+		if 0 < n {
+			e.buffer.WriteByte(',')
 		}
+		n++
+		e.writeString(f.name)
 		e.buffer.WriteByte(':')
-		err := e.encodeValue(path+"."+k.Interface().(string), v.MapIndex(k))
+		var err error
+		e.pushKey(f.name)
+		if f.quoted {
+			err = e.encodeQuoted(fv)
+		} else {
+			err = e.encodeValue(fv)
+		}
+		e.pop()
 		if err != nil {
 			return err
 		}
@@ -206,13 +1113,68 @@ func (e *encoder) encodeMap(path string, v reflect.Value) error {
 	return nil
 }
 
-func (e *encoder) encodeArray(path string, v reflect.Value) error {
+// fieldLess reports whether the field a is written before b with
+// KeepFieldOrder: the keys in KeyPriority first, the other fields in
+// the order of the declaration, and the keys in the rest field sorted.
+func (e *encoder) fieldLess(a, b structField) bool {
+	_, pa := e.rank(a.name)
+	_, pb := e.rank(b.name)
+	if pa || pb || a.rest && b.rest {
+		return e.keyLess(a.name, b.name)
+	}
+	return !a.rest && b.rest
+}
+
+// encodeQuoted encodes the value of a field with the "string" option
+// as a string holding its JSON encoding.
+func (e *encoder) encodeQuoted(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		v = v.Elem()
+	}
+	j, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	e.writeString(string(j))
+	return nil
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	if v.Kind() == reflect.Slice {
+		if v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		err := e.enterPtr(v)
+		if err != nil {
+			return err
+		}
+		defer e.leavePtr(v)
+	}
+	var order []int
+	if e.SortArrays {
+		order = make([]int, v.Len())
+		for i := range order {
+			order[i] = i
+		}
+		sortScalars(order, v)
+	}
 	e.buffer.WriteString("!(")
-	for i := 0; i < v.Len(); i++ {
-		if 0 < i {
+	for n := 0; n < v.Len(); n++ {
+		if 0 < n {
 			e.buffer.WriteByte(',')
 		}
-		err := e.encodeValue(fmt.Sprintf("%s[%d]", path, i), v.Index(i))
+		i := n
+		if order != nil {
+			i = order[n]
+		}
+		e.pushIndex(i)
+		err := e.encodeValue(v.Index(i))
+		e.pop()
 		if err != nil {
 			return err
 		}
@@ -221,51 +1183,268 @@ func (e *encoder) encodeArray(path string, v reflect.Value) error {
 	return nil
 }
 
-func (e *encoder) encodeValue(path string, v reflect.Value) error {
+// sortScalars sorts the indexes of the elements of the array v by the
+// elements, if all of them are numbers, all are strings, or all are
+// booleans.
+func sortScalars(order []int, v reflect.Value) {
+	if len(order) < 2 {
+		return
+	}
+	type scalar struct {
+		class  reflect.Kind
+		number float64
+		str    string
+	}
+	elems := make([]scalar, len(order))
+	for i := range elems {
+		elem := v.Index(i)
+		for elem.Kind() == reflect.Interface && !elem.IsNil() {
+			elem = elem.Elem()
+		}
+		t := elem.Type()
+		if t.Kind() != reflect.Interface && (t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType)) {
+			return
+		}
+		switch elem.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			elems[i] = scalar{class: reflect.Float64, number: float64(elem.Int())}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			elems[i] = scalar{class: reflect.Float64, number: float64(elem.Uint())}
+		case reflect.Float32, reflect.Float64:
+			elems[i] = scalar{class: reflect.Float64, number: elem.Float()}
+		case reflect.String:
+			if t == jsonNumberType {
+				f, err := strconv.ParseFloat(elem.String(), 64)
+				if err != nil {
+					return
+				}
+				elems[i] = scalar{class: reflect.Float64, number: f}
+			} else {
+				elems[i] = scalar{class: reflect.String, str: elem.String()}
+			}
+		case reflect.Bool:
+			elems[i] = scalar{class: reflect.Bool}
+			if elem.Bool() {
+				elems[i].number = 1
+			}
+		default:
+			return
+		}
+		if elems[i].class != elems[0].class {
+			return
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := elems[order[i]], elems[order[j]]
+		if a.class == reflect.String {
+			return a.str < b.str
+		}
+		return a.number < b.number
+	})
+}
+
+func (e *encoder) encodeValue(v reflect.Value) error {
 	var errDetail error
+	start := e.buffer.Len()
+	container := false
 
-	switch v.Kind() {
+	switch {
 
-	case reflect.Bool:
-		errDetail = e.encodeBool(path, v)
+	case !v.IsValid():
+		e.buffer.WriteString("!n")
 
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-		reflect.Float32, reflect.Float64:
-		errDetail = e.encodeNumber(path, v)
+	case v.Kind() == reflect.Interface:
+		if v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		return e.encodeValue(v.Elem())
+
+	case v.Type() == rawMessageType:
+		// the JSON is encoded inline, not as the bytes
+		if v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		errDetail = e.encodeJSON(v.Bytes())
 
-	case reflect.String:
-		if !e.writeString(v) {
-			errDetail = fmt.Errorf("internal error")
+	case e.ZeroTimeAsNull && isZeroTime(v):
+		e.buffer.WriteString("!n")
+
+	case e.TimeFormat == TimeUnixSeconds && (v.Type() == timeType || v.Type() == reflect.PtrTo(timeType)) && v.CanInterface():
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				e.buffer.WriteString("!n")
+				return nil
+			}
+			v = v.Elem()
 		}
+		e.buffer.Write(appendUnixSeconds(nil, v.Interface().(time.Time)))
 
-	case reflect.Map:
-		errDetail = e.encodeMap(path, v)
+	case v.Type().Implements(marshalerType), v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType):
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		if !v.Type().Implements(marshalerType) {
+			v = v.Addr()
+		}
+		var r []byte
+		r, errDetail = v.Interface().(Marshaler).MarshalRison()
+		if errDetail == nil {
+			errDetail = (&parser{Mode: Rison}).walk(r, discard{})
+		}
+		if errDetail == nil {
+			e.buffer.Write(r)
+		}
+
+	case v.Type().Implements(jsonMarshalerType), v.CanAddr() && reflect.PtrTo(v.Type()).Implements(jsonMarshalerType):
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		if !v.Type().Implements(jsonMarshalerType) {
+			v = v.Addr()
+		}
+		var j []byte
+		j, errDetail = v.Interface().(json.Marshaler).MarshalJSON()
+		if errDetail == nil {
+			errDetail = e.encodeJSON(j)
+		}
+
+	case v.Type().Implements(textMarshalerType), v.CanAddr() && reflect.PtrTo(v.Type()).Implements(textMarshalerType):
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		if !v.Type().Implements(textMarshalerType) {
+			v = v.Addr()
+		}
+		var b []byte
+		b, errDetail = v.Interface().(encoding.TextMarshaler).MarshalText()
+		if errDetail == nil {
+			e.writeString(string(b))
+		}
 
-	case reflect.Slice, reflect.Array:
-		errDetail = e.encodeArray(path, v)
+	case e.UseStringer && (v.Type().Implements(stringerType) || v.CanAddr() && reflect.PtrTo(v.Type()).Implements(stringerType)):
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			e.buffer.WriteString("!n")
+			return nil
+		}
+		if !v.Type().Implements(stringerType) {
+			v = v.Addr()
+		}
+		e.writeString(v.Interface().(fmt.Stringer).String())
 
-	case reflect.Ptr, reflect.Interface:
+	case e.AllowIterators && iterArity(v.Type()) != 0:
 		if v.IsNil() {
 			e.buffer.WriteString("!n")
 			return nil
 		}
-		return e.encodeValue(path, v.Elem())
+		container = true
+		err := e.enter()
+		if err != nil {
+			return err
+		}
+		if iterArity(v.Type()) == 1 {
+			errDetail = e.encodeSeq(v)
+		} else {
+			errDetail = e.encodeSeq2(v)
+		}
+		e.leave()
 
 	default:
-		errDetail = fmt.Errorf("%s is non-supported kind", v.Kind())
+		switch v.Kind() {
+
+		case reflect.Bool:
+			errDetail = e.encodeBool(v)
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			errDetail = e.encodeNumber(v)
+
+		case reflect.String:
+			if v.Type() == jsonNumberType {
+				errDetail = e.encodeJSONNumber(v)
+			} else {
+				e.writeString(v.String())
+			}
+
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+			if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 &&
+				!reflect.PtrTo(v.Type().Elem()).Implements(jsonMarshalerType) &&
+				!reflect.PtrTo(v.Type().Elem()).Implements(textMarshalerType) {
+				if v.IsNil() {
+					e.buffer.WriteString("!n")
+				} else {
+					e.writeString(base64.StdEncoding.EncodeToString(v.Bytes()))
+				}
+				break
+			}
+			container = true
+			err := e.enter()
+			if err != nil {
+				return err
+			}
+			switch v.Kind() {
+			case reflect.Map:
+				errDetail = e.encodeMap(v)
+			case reflect.Struct:
+				errDetail = e.encodeStruct(v)
+			default:
+				errDetail = e.encodeArray(v)
+			}
+			e.leave()
+
+		case reflect.Ptr:
+			if v.IsNil() {
+				e.buffer.WriteString("!n")
+				return nil
+			}
+			err := e.enterPtr(v)
+			if err != nil {
+				errDetail = err
+				break
+			}
+			err = e.encodeValue(v.Elem())
+			e.leavePtr(v)
+			return err
+
+		default:
+			errDetail = fmt.Errorf("%s is non-supported kind", v.Kind())
+		}
 	}
 
 	if errDetail == nil {
+		if e.report != nil && !container {
+			if n := e.buffer.Len() - start; e.report.LargestLen < n {
+				e.report.LargestPath = e.pathString()
+				e.report.LargestLen = n
+			}
+		}
 		return nil
 	}
 
-	if path == "" {
-		path = "."
+	if _, ok := errDetail.(*encodeError); ok {
+		// reported by a nested value already
+		return errDetail
 	}
-	var vi interface{} = v
-	if v.IsValid() && v.CanInterface() {
-		vi = v.Interface()
+	typ := "nil"
+	if v.IsValid() {
+		typ = v.Type().String()
 	}
-	return fmt.Errorf("non-encodable %s value at %s in %+v: %s", v.Kind(), path, vi, errDetail.Error())
+	return &encodeError{fmt.Sprintf("non-encodable %s value at %s of type %s: %s", v.Kind(), e.pathString(), typ, errDetail.Error())}
+}
+
+// encodeError is the error of a value which cannot be encoded, with
+// the path to it. It is returned as it is through the enclosing values,
+// so that the message does not grow with the nesting, such as that of
+// a cycle.
+type encodeError struct {
+	msg string
+}
+
+func (e *encodeError) Error() string {
+	return e.msg
 }
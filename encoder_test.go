@@ -30,3 +30,18 @@ func ExampleFromJSON() {
 	fmt.Printf("%s\n", string(r))
 	// Output: !(1,2.3,str,'-ing',true,nil,(a:b),!(7,8,9))
 }
+
+func ExampleMarshalIndent() {
+	v := map[string]interface{}{"id": 1, "tags": []string{"a", "b c"}, "none": map[string]int{}}
+	r, _ := rison.MarshalIndent(v, rison.Rison, "", "  ")
+	fmt.Println(string(r))
+	// Output:
+	// (
+	//   id:1,
+	//   none:(),
+	//   tags:!(
+	//     a,
+	//     'b c'
+	//   )
+	// )
+}
@@ -1,7 +1,11 @@
 package rison
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 var errorMessage = map[string]map[ErrType]string{
@@ -20,6 +24,19 @@ var errorMessage = map[string]map[ErrType]string{
 		EInvalidStringEscape:         `invalid string escape "!%c"`,
 		EInvalidNumber:               `invalid number "%s"`,
 		EInvalidLargeExp:             `large case "E" for exponent cannot be used`,
+		ETypeMismatch:                `cannot unmarshal %s into "%s" of type %s`,
+		EEmptyKey:                    `empty object key`,
+		EArrayLength:                 `array of %[1]d elements cannot be stored in "%[2]s" of type %[3]s with %[4]d elements`,
+		EKeysExceeded:                `object has more than %d keys`,
+		EInvalidKey:                  `invalid key "%s" for %s: %s`,
+		EStringTooLong:               `string longer than %d bytes`,
+		EStringBytesExceeded:         `strings longer than %d bytes in total`,
+		ENonMinimalNumber:            `number "%s" must be written as "%s"`,
+		EMaxDepthExceeded:            `arrays and objects nested deeper than %d`,
+		EInputTooLong:                `input of %d bytes longer than %d bytes`,
+		EDuplicateKey:                `duplicate object key "%s"`,
+		ELeadingZero:                 `number "%s" must not have leading zeros`,
+		EUnknownField:                `unknown key "%s" for %s`,
 	},
 	"ja": {
 		EInternal:                    `内部エラー: %s`,
@@ -36,41 +53,70 @@ var errorMessage = map[string]map[ErrType]string{
 		EInvalidStringEscape:         `不正なエスケープ文字列 "!%c" が見つかりました`,
 		EInvalidNumber:               `不正な数値 "%s" が見つかりました`,
 		EInvalidLargeExp:             `指数表記に大文字の "E" は使用できません`,
+		ETypeMismatch:                `%[1]s を %[3]s 型の "%[2]s" に格納できません`,
+		EEmptyKey:                    `オブジェクトキーが空です`,
+		EArrayLength:                 `%[1]d 要素の配列を要素数 %[4]d の %[3]s 型の "%[2]s" に格納できません`,
+		EKeysExceeded:                `オブジェクトのキーが %d 個を超えています`,
+		EInvalidKey:                  `"%[1]s" は %[2]s 型のキーとして不正です: %[3]s`,
+		EStringTooLong:               `文字列が %d バイトを超えています`,
+		EStringBytesExceeded:         `文字列が合計 %d バイトを超えています`,
+		ENonMinimalNumber:            `数値 "%s" は "%s" と記述する必要があります`,
+		EMaxDepthExceeded:            `配列とオブジェクトの入れ子が %d 段を超えています`,
+		EInputTooLong:                `入力の %d バイトが %d バイトを超えています`,
+		EDuplicateKey:                `オブジェクトキー "%s" が重複しています`,
+		ELeadingZero:                 `数値 "%s" の先頭に余分な 0 があります`,
+		EUnknownField:                `"%[1]s" は %[2]s 型に存在しないキーです`,
 	},
 }
 
-type errPos int
+// ErrPos is the kind of the description of the position in the error
+// messages, for RegisterLanguage. The comments show the arguments of
+// the formats; "before" and "after" are up to 5 characters around the
+// position, and "..left" and "right.." are ErrPosEllipsisLeft and
+// ErrPosEllipsisRight if they are truncated.
+type ErrPos int
 
 const (
-	errPosNear errPos = iota
-	errPosFirst
-	errPosStart
-	errPosEnd
-	errPosLast
-	errPosEllipsisLeft
-	errPosEllipsisRight
+	// ErrPosNear is in the middle: index, ..left, before, character, after and right..
+	ErrPosNear ErrPos = iota
+	// ErrPosFirst is at the only character: character.
+	ErrPosFirst
+	// ErrPosStart is at the first character: character, after and right..
+	ErrPosStart
+	// ErrPosEnd is at the end: ..left and before.
+	ErrPosEnd
+	// ErrPosLast is at the last character: ..left, before and character.
+	ErrPosLast
+	// ErrPosEllipsisLeft is the ellipsis of the truncated characters before the position.
+	ErrPosEllipsisLeft
+	// ErrPosEllipsisRight is the ellipsis of the truncated characters after the position.
+	ErrPosEllipsisRight
 )
 
 var errLangs = []string{"en", "ja"}
 
-var errPosDesc = map[string]map[errPos]string{
+// errLangsMu guards errLangs, errorMessage and errPosDesc
+// against RegisterLanguage.
+var errLangsMu sync.RWMutex
+
+var errPosDesc = map[string]map[ErrPos]string{
 	"en": {
-		errPosNear:          ` (at [%d] near %s"%s" -> "%s" -> "%s"%s)`,
-		errPosFirst:         ` (at the first character "%s")`,
-		errPosStart:         ` (at the first character "%s" -> "%s"%s)`,
-		errPosEnd:           ` (at the end of string %s"%s" -> EOS)`,
-		errPosLast:          ` (at the last character %s"%s" -> "%s")`,
-		errPosEllipsisLeft:  `.. `,
-		errPosEllipsisRight: ` ..`,
+		ErrPosNear:          ` (at [%d] near %s"%s" -> "%s" -> "%s"%s)`,
+		ErrPosFirst:         ` (at the first character "%s")`,
+		ErrPosStart:         ` (at the first character "%s" -> "%s"%s)`,
+		ErrPosEnd:           ` (at the end of string %s"%s" -> EOS)`,
+		ErrPosLast:          ` (at the last character %s"%s" -> "%s")`,
+		ErrPosEllipsisLeft:  `.. `,
+		ErrPosEllipsisRight: ` ..`,
 	},
 	"ja": {
-		errPosNear:          ` (場所: [%d]付近: %s"%s" → "%s" → "%s"%s)`,
-		errPosFirst:         ` (場所: 先頭文字: "%s")`,
-		errPosStart:         ` (場所: 先頭文字付近: "%s" → "%s"%s)`,
-		errPosEnd:           ` (場所: 文字列終端: %s"%s" → EOS)`,
-		errPosLast:          ` (場所: 終端文字: %s"%s" → "%s")`,
-		errPosEllipsisLeft:  `〜 `,
-		errPosEllipsisRight: ` 〜`,
+		ErrPosNear:          ` (場所: [%d]付近: %s"%s" → "%s" → "%s"%s)`,
+		ErrPosFirst:         ` (場所: 先頭文字: "%s")`,
+		ErrPosStart:         ` (場所: 先頭文字付近: "%s" → "%s"%s)`,
+		ErrPosEnd:           ` (場所: 文字列終端: %s"%s" → EOS)`,
+		ErrPosLast:          ` (場所: 終端文字: %s"%s" → "%s")`,
+		ErrPosEllipsisLeft:  `〜 `,
+		ErrPosEllipsisRight: ` 〜`,
 	},
 }
 
@@ -81,16 +127,73 @@ type ParseError struct {
 	Args  []interface{}
 	Src   []byte
 	Pos   int
-	lang  string
+	// WrappedSrc is the input as parsed internally, wrapped with "(" and
+	// ")" in the O-Rison mode or "!(" and ")" in the A-Rison mode, kept
+	// only with DecodeOptions.KeepWrappedSrc for debugging. The error is
+	// at Pos+1 or Pos+2 in it respectively.
+	WrappedSrc []byte
+	lang       string
+	path       string
 }
 
 func (e *ParseError) Error() string {
 	return e.ErrorInLang(e.lang)
 }
 
+// Unwrap returns the underlying error, such as *json.UnmarshalTypeError.
+func (e *ParseError) Unwrap() error {
+	return e.Child
+}
+
+// Is reports whether target is the ErrType of the error,
+// for errors.Is(err, EUnmatchedPair).
+func (e *ParseError) Is(target error) bool {
+	t, ok := target.(ErrType)
+	return ok && t == e.Type
+}
+
+// ErrorType returns the type of the error, the same as the field Type.
+func (e *ParseError) ErrorType() ErrType {
+	return e.Type
+}
+
+// Position returns the position in Src where the error occurred,
+// the same as the field Pos.
+func (e *ParseError) Position() int {
+	return e.Pos
+}
+
+// LineColumn returns the 1-based line and column numbers of Pos in Src,
+// for the inputs written in multiple lines with SkipWhitespaces.
+// The lines are separated by "\n", and the column counts the
+// characters, not the bytes, from the start of the line.
+func (e *ParseError) LineColumn() (line, col int) {
+	pos := e.Pos
+	if pos < 0 {
+		pos = 0
+	}
+	if len(e.Src) < pos {
+		pos = len(e.Src)
+	}
+	before := e.Src[:pos]
+	start := bytes.LastIndexByte(before, '\n') + 1
+	return bytes.Count(before, []byte{'\n'}) + 1, utf8.RuneCount(before[start:]) + 1
+}
+
+// Path returns the JSONPath-like locator of the value where the error
+// occurred, such as $.filters[2].range.min. The root is $.
+func (e *ParseError) Path() string {
+	if e.path == "" {
+		return "$"
+	}
+	return e.path
+}
+
 // Langs returns supported languages.
 func (e *ParseError) Langs() []string {
-	return errLangs
+	errLangsMu.RLock()
+	defer errLangsMu.RUnlock()
+	return append([]string{}, errLangs...)
 }
 
 // Translate sets the language of the error message to be retrieved by Error().
@@ -100,6 +203,8 @@ func (e *ParseError) Translate(lang string) {
 
 // ErrorInLang returns the error message in specified language.
 func (e *ParseError) ErrorInLang(lang string) string {
+	errLangsMu.RLock()
+	defer errLangsMu.RUnlock()
 	desc, ok := errPosDesc[lang]
 	if !ok {
 		desc = errPosDesc["en"]
@@ -107,30 +212,30 @@ func (e *ParseError) ErrorInLang(lang string) string {
 	n := 5
 	ll := ""
 	if 0 < e.Pos-n {
-		ll = desc[errPosEllipsisLeft]
+		ll = desc[ErrPosEllipsisLeft]
 	}
 	l := string(substrLimited(e.Src, e.Pos-n, n))
 	c := string(substrLimited(e.Src, e.Pos, 1))
 	r := string(substrLimited(e.Src, e.Pos+1, n))
 	rr := ""
 	if e.Pos+1+n < len(e.Src) {
-		rr = desc[errPosEllipsisRight]
+		rr = desc[ErrPosEllipsisRight]
 	}
-	w := fmt.Sprintf(desc[errPosNear], e.Pos, ll, l, c, r, rr)
+	w := fmt.Sprintf(desc[ErrPosNear], e.Pos, ll, l, c, r, rr)
 	if l == "" {
 		if r == "" {
 			if c == "" {
 				w = ""
 			} else {
-				w = fmt.Sprintf(desc[errPosFirst], c)
+				w = fmt.Sprintf(desc[ErrPosFirst], c)
 			}
 		} else {
-			w = fmt.Sprintf(desc[errPosStart], c, r, rr)
+			w = fmt.Sprintf(desc[ErrPosStart], c, r, rr)
 		}
 	} else if c == "" {
-		w = fmt.Sprintf(desc[errPosEnd], ll, l)
+		w = fmt.Sprintf(desc[ErrPosEnd], ll, l)
 	} else if r == "" {
-		w = fmt.Sprintf(desc[errPosLast], ll, l, c)
+		w = fmt.Sprintf(desc[ErrPosLast], ll, l, c)
 	}
 	msgdef, ok := errorMessage[lang]
 	if !ok {
@@ -149,3 +254,117 @@ func (e *ParseError) ErrorInLang(lang string) string {
 	//}
 	return result
 }
+
+// errorContextRunes is the number of the characters shown on each side
+// of the error position by ErrorContext and ErrorColored.
+const errorContextRunes = 30
+
+// ErrorContext returns the message in the language, as ErrorInLang
+// does, followed by the lines showing the source around the error
+// position and a caret "^" under the character at the position. The
+// caret is aligned by counting the characters, not their display
+// widths. The control characters in the source are shown as spaces.
+// It is for the outputs other than the terminals, such as the logs.
+func (e *ParseError) ErrorContext(lang string) string {
+	return e.errorContext(lang, false)
+}
+
+// ErrorColored returns the same lines as ErrorContext, with the caret
+// colored red with the ANSI escape sequences for the terminals.
+func (e *ParseError) ErrorColored(lang string) string {
+	return e.errorContext(lang, true)
+}
+
+func (e *ParseError) errorContext(lang string, color bool) string {
+	pos := e.Pos
+	if pos < 0 {
+		pos = 0
+	}
+	if len(e.Src) < pos {
+		pos = len(e.Src)
+	}
+	left := []rune(string(e.Src[:pos]))
+	right := []rune(string(e.Src[pos:]))
+	ll, rr := "", ""
+	if errorContextRunes < len(left) {
+		left = left[len(left)-errorContextRunes:]
+		ll = "..."
+	}
+	if errorContextRunes < len(right) {
+		right = right[:errorContextRunes]
+		rr = "..."
+	}
+	visible := func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}
+	var b strings.Builder
+	b.WriteString(e.ErrorInLang(lang))
+	b.WriteString("\n")
+	b.WriteString(ll)
+	b.WriteString(strings.Map(visible, string(left)))
+	b.WriteString(strings.Map(visible, string(right)))
+	b.WriteString(rr)
+	b.WriteString("\n")
+	b.WriteString(strings.Repeat(" ", len(ll)+len(left)))
+	if color {
+		b.WriteString("\x1b[31m^\x1b[0m")
+	} else {
+		b.WriteString("^")
+	}
+	return b.String()
+}
+
+// RegisterLanguage adds the language of the error messages, such as "fr",
+// to be used by ErrorInLang and Translate. The messages must have the
+// formats of all the error types taking the same arguments as the
+// built-in ones, and the positions must have all the ErrPos.
+// It fails if the language is already registered, including the
+// built-in "en" and "ja"; use OverwriteLanguage to replace one.
+//
+// The languages are shared by the whole program, so they should be
+// registered at the initialization.
+func RegisterLanguage(lang string, messages map[ErrType]string, positions map[ErrPos]string) error {
+	return registerLanguage(lang, messages, positions, false)
+}
+
+// OverwriteLanguage is like RegisterLanguage but replaces the messages
+// of the language if it is already registered.
+func OverwriteLanguage(lang string, messages map[ErrType]string, positions map[ErrPos]string) error {
+	return registerLanguage(lang, messages, positions, true)
+}
+
+func registerLanguage(lang string, messages map[ErrType]string, positions map[ErrPos]string, overwrite bool) error {
+	for t := range errTypeNames {
+		if _, ok := messages[t]; !ok {
+			return fmt.Errorf("no message of %s in the language %s", t.Error(), lang)
+		}
+	}
+	for p := ErrPosNear; p <= ErrPosEllipsisRight; p++ {
+		if _, ok := positions[p]; !ok {
+			return fmt.Errorf("no description of the position %d in the language %s", int(p), lang)
+		}
+	}
+	errLangsMu.Lock()
+	defer errLangsMu.Unlock()
+	_, exists := errorMessage[lang]
+	if exists && !overwrite {
+		return fmt.Errorf("the language %s is already registered", lang)
+	}
+	m := make(map[ErrType]string, len(messages))
+	for t, msg := range messages {
+		m[t] = msg
+	}
+	d := make(map[ErrPos]string, len(positions))
+	for p, desc := range positions {
+		d[p] = desc
+	}
+	errorMessage[lang] = m
+	errPosDesc[lang] = d
+	if !exists {
+		errLangs = append(errLangs, lang)
+	}
+	return nil
+}
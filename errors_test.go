@@ -1,6 +1,12 @@
 package rison
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
 
 type errorInLang interface {
 	error
@@ -37,3 +43,215 @@ func TestParseError_Translate(t *testing.T) {
 		t.Errorf(`(*ParseError).Error: want %s, got %s`, want, e.Error())
 	}
 }
+
+func TestParseError_ErrorType(t *testing.T) {
+	cases := []struct {
+		r   string
+		typ ErrType
+		pos int
+	}{
+		{"(a:'x", EUnmatchedPair, 5},
+		{"(a:1)x", EExtraCharacterAfterRison, 5},
+		{"!x", EInvalidLiteral, 1},
+		{"(a:'!x')", EInvalidStringEscape, 6},
+		{"", EEmptyString, 0},
+	}
+	for _, c := range cases {
+		_, err := Decode([]byte(c.r), Rison)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %#v", c.r, err)
+			continue
+		}
+		if e.ErrorType() != c.typ || e.ErrorType() != e.Type {
+			t.Errorf("(*ParseError).ErrorType of %s : want %d, got %d", c.r, c.typ, e.ErrorType())
+		}
+		if e.Position() != c.pos || e.Position() != e.Pos {
+			t.Errorf("(*ParseError).Position of %s : want %d, got %d", c.r, c.pos, e.Position())
+		}
+	}
+}
+
+func TestParseError_Is(t *testing.T) {
+	cases := map[string]ErrType{
+		"(a:'x":  EUnmatchedPair,
+		"(a:1)x": EExtraCharacterAfterRison,
+		"!x":     EInvalidLiteral,
+	}
+	for r, typ := range cases {
+		_, err := Decode([]byte(r), Rison)
+		if !errors.Is(err, typ) {
+			t.Errorf("decoding %s : want errors.Is %s, got %v", r, typ.Error(), err)
+		}
+		if errors.Is(err, EEmptyString) {
+			t.Errorf("decoding %s : want not errors.Is %s, got %v", r, EEmptyString.Error(), err)
+		}
+		wrapped := fmt.Errorf("query: %w", err)
+		if !errors.Is(wrapped, typ) {
+			t.Errorf("decoding %s : want errors.Is %s for the wrapped error, got %v", r, typ.Error(), wrapped)
+		}
+		var pe *ParseError
+		if !errors.As(wrapped, &pe) || pe.Type != typ {
+			t.Errorf("decoding %s : want errors.As *ParseError, got %v", r, wrapped)
+		}
+	}
+
+	var s struct {
+		A int `json:"a"`
+	}
+	err := Unmarshal([]byte("(a:x)"), &s, Rison)
+	var te *json.UnmarshalTypeError
+	if !errors.Is(err, ETypeMismatch) || !errors.As(err, &te) {
+		t.Errorf("decoding (a:x) : want ETypeMismatch wrapping *json.UnmarshalTypeError, got %#v", err)
+	}
+
+	for typ := EInternal; typ < eLast; typ++ {
+		if msg := typ.Error(); !strings.HasPrefix(msg, "rison: ") || strings.Contains(msg, "error type") {
+			t.Errorf("ErrType(%d).Error : want a description, got %s", int(typ), msg)
+		}
+	}
+}
+
+func TestRegisterLanguage(t *testing.T) {
+	messages := map[ErrType]string{}
+	for typ := EInternal; typ < eLast; typ++ {
+		messages[typ] = errorMessage["en"][typ]
+	}
+	messages[EUnmatchedPair] = `"%s" non fermé`
+	positions := map[ErrPos]string{
+		ErrPosNear:          ` (à [%d] près de %s"%s" -> "%s" -> "%s"%s)`,
+		ErrPosFirst:         ` (au premier caractère "%s")`,
+		ErrPosStart:         ` (au premier caractère "%s" -> "%s"%s)`,
+		ErrPosEnd:           ` (à la fin de la chaîne %s"%s" -> EOS)`,
+		ErrPosLast:          ` (au dernier caractère %s"%s" -> "%s")`,
+		ErrPosEllipsisLeft:  `.. `,
+		ErrPosEllipsisRight: ` ..`,
+	}
+
+	if err := RegisterLanguage("en", messages, positions); err == nil {
+		t.Errorf("RegisterLanguage en : want error, got nil")
+	}
+	incomplete := map[ErrType]string{EUnmatchedPair: messages[EUnmatchedPair]}
+	if err := RegisterLanguage("fr-incomplete", incomplete, positions); err == nil {
+		t.Errorf("RegisterLanguage with the missing messages : want error, got nil")
+	}
+	if err := RegisterLanguage("fr-incomplete", messages, map[ErrPos]string{}); err == nil {
+		t.Errorf("RegisterLanguage with the missing positions : want error, got nil")
+	}
+
+	t.Cleanup(func() {
+		errLangsMu.Lock()
+		defer errLangsMu.Unlock()
+		delete(errorMessage, "fr")
+		delete(errPosDesc, "fr")
+		errLangs = errLangs[:len(errLangs)-1]
+	})
+	if err := RegisterLanguage("fr", messages, positions); err != nil {
+		t.Fatalf("RegisterLanguage fr : want no error, got error `%s`", err.Error())
+	}
+	if err := RegisterLanguage("fr", messages, positions); err == nil {
+		t.Errorf("RegisterLanguage fr again : want error, got nil")
+	}
+	if err := OverwriteLanguage("fr", messages, positions); err != nil {
+		t.Errorf("OverwriteLanguage fr : want no error, got error `%s`", err.Error())
+	}
+	_, err := Decode([]byte(`(`), Rison)
+	e := err.(*ParseError)
+	want := `"(" non fermé (à la fin de la chaîne "(" -> EOS)`
+	if e.ErrorInLang("fr") != want {
+		t.Errorf(`(*ParseError).ErrorInLang: want %s, got %s`, want, e.ErrorInLang("fr"))
+	}
+	want = `unmatched "(" (at the end of string "(" -> EOS)`
+	if e.ErrorInLang("en") != want {
+		t.Errorf(`(*ParseError).ErrorInLang: want %s, got %s`, want, e.ErrorInLang("en"))
+	}
+	langs := e.Langs()
+	if len(langs) != 3 || langs[0] != "en" || langs[1] != "ja" || langs[2] != "fr" {
+		t.Errorf("(*ParseError).Langs : want en, ja and fr, got %v", langs)
+	}
+}
+
+func TestParseError_WrappedSrc(t *testing.T) {
+	cases := []struct {
+		r       string
+		m       Mode
+		wrapped string
+		offset  int
+	}{
+		{"a:1,b:!x", ORison, "(a:1,b:!x)", 1},
+		{"1,!x", ARison, "!(1,!x)", 2},
+		{"(a:!x)", Rison, "(a:!x)", 0},
+	}
+	for _, c := range cases {
+		_, err := DecodeWithOptions([]byte(c.r), c.m, DecodeOptions{KeepWrappedSrc: true})
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %#v", c.r, err)
+			continue
+		}
+		if string(e.Src) != c.r || string(e.WrappedSrc) != c.wrapped {
+			t.Errorf("decoding %s : want the sources %s and %s, got %s and %s", c.r, c.r, c.wrapped, e.Src, e.WrappedSrc)
+		}
+		if e.WrappedSrc[e.Pos+c.offset] != e.Src[e.Pos] {
+			t.Errorf("decoding %s : want the same character at %d in %s, got %c", c.r, e.Pos+c.offset, e.WrappedSrc, e.WrappedSrc[e.Pos+c.offset])
+		}
+
+		_, err = Decode([]byte(c.r), c.m)
+		if e, ok := err.(*ParseError); !ok || e.WrappedSrc != nil {
+			t.Errorf("decoding %s without the option : want no WrappedSrc, got %#v", c.r, err)
+		}
+	}
+}
+
+func TestParseError_ErrorColored(t *testing.T) {
+	cases := []struct {
+		r    string
+		want string
+	}{
+		{"(a:!x)", "(a:!x)\n    \x1b[31m^\x1b[0m"},
+		{"(", "(\n \x1b[31m^\x1b[0m"},
+		{"(名前:'値',x:!z)", "(名前:'値',x:!z)\n           \x1b[31m^\x1b[0m"},
+		{"!(" + strings.Repeat("1,", 30) + "!x," + strings.Repeat("2,", 20) + "3)", "...," + strings.Repeat("1,", 14) + "!x," + strings.Repeat("2,", 14) + "...\n" + strings.Repeat(" ", 33) + "\x1b[31m^\x1b[0m"},
+	}
+	for _, c := range cases {
+		_, err := Decode([]byte(c.r), Rison)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %#v", c.r, err)
+			continue
+		}
+		want := e.ErrorInLang("ja") + "\n" + c.want
+		if got := e.ErrorColored("ja"); got != want {
+			t.Errorf("(*ParseError).ErrorColored of %s : want %q, got %q", c.r, want, got)
+		}
+		want = strings.Replace(want, "\x1b[31m^\x1b[0m", "^", 1)
+		if got := e.ErrorContext("ja"); got != want {
+			t.Errorf("(*ParseError).ErrorContext of %s : want %q, got %q", c.r, want, got)
+		}
+	}
+}
+
+func TestParseError_LineColumn(t *testing.T) {
+	cases := []struct {
+		r         string
+		line, col int
+	}{
+		{"(a:!x)", 1, 5},
+		{"(\n  a:1,\n  b:!x\n)", 3, 6},
+		{"(\n  名前:'値',\n  x:!z\n)", 3, 6},
+		{"(\n  a:1,\n", 3, 1},
+		{"\n(a:1)x", 2, 6},
+	}
+	for _, c := range cases {
+		_, err := DecodeWithOptions([]byte(c.r), Rison, DecodeOptions{SkipWhitespaces: true})
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %q : want *ParseError, got %#v", c.r, err)
+			continue
+		}
+		line, col := e.LineColumn()
+		if line != c.line || col != c.col {
+			t.Errorf("decoding %q : want the error at line %d column %d, got line %d column %d (Pos %d)", c.r, c.line, c.col, line, col, e.Pos)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package rison
 
+import "fmt"
+
 // ErrType is an enum type of error
+//
+// ErrType implements error, so that errors.Is reports whether an error
+// is a *ParseError of the type, such as errors.Is(err, EUnmatchedPair).
 type ErrType int
 
 const (
@@ -32,4 +37,72 @@ const (
 	EInvalidNumber
 	// EInvalidLargeExp is an error indicating an upper case "E" is used as an exponent.
 	EInvalidLargeExp
+	// ETypeMismatch is an error indicating a value cannot be stored in the Go value of the corresponding type.
+	ETypeMismatch
+	// EEmptyKey is an error indicating an empty object key was found.
+	EEmptyKey
+	// EArrayLength is an error indicating an array cannot be stored in the Go array of a different length.
+	EArrayLength
+	// EKeysExceeded is an error indicating an object has more keys than the limit.
+	EKeysExceeded
+	// EInvalidKey is an error indicating an object key cannot be stored as the key of the Go map.
+	EInvalidKey
+	// EStringTooLong is an error indicating a string is longer than the limit.
+	EStringTooLong
+	// EStringBytesExceeded is an error indicating the strings are longer than the limit in total.
+	EStringBytesExceeded
+	// ENonMinimalNumber is an error indicating a number is not written in the shortest form.
+	ENonMinimalNumber
+	// EMaxDepthExceeded is an error indicating arrays or objects are nested deeper than the limit.
+	EMaxDepthExceeded
+	// EInputTooLong is an error indicating the input is longer than the limit.
+	EInputTooLong
+	// EDuplicateKey is an error indicating an object has the same key more than once.
+	EDuplicateKey
+	// ELeadingZero is an error indicating a number starts with an extra zero.
+	ELeadingZero
+	// EUnknownField is an error indicating an object key has no field in the Go struct.
+	EUnknownField
+
+	// eLast is next to the last error type, for the tests to loop over
+	// all of them. New error types are added before it.
+	eLast
 )
+
+var errTypeNames = map[ErrType]string{
+	EInternal:                    "internal error",
+	EEncoding:                    "invalid encoding",
+	EEmptyString:                 "empty string",
+	EUnmatchedPair:               "unmatched pair",
+	EMissingCharacter:            "missing character",
+	EMissingCharacterAfterEscape: "missing character after escape",
+	EExtraCharacter:              "extra character",
+	EExtraCharacterAfterRison:    "extra character after Rison",
+	EInvalidLiteral:              "invalid literal",
+	EInvalidCharacter:            "invalid character",
+	EInvalidTypeOfObjectKey:      "invalid type of object key",
+	EInvalidStringEscape:         "invalid string escape",
+	EInvalidNumber:               "invalid number",
+	EInvalidLargeExp:             "invalid large exponent",
+	ETypeMismatch:                "type mismatch",
+	EEmptyKey:                    "empty key",
+	EArrayLength:                 "array length mismatch",
+	EKeysExceeded:                "too many keys",
+	EInvalidKey:                  "invalid key",
+	EStringTooLong:               "string too long",
+	EStringBytesExceeded:         "strings too long in total",
+	ENonMinimalNumber:            "non-minimal number",
+	EMaxDepthExceeded:            "nesting too deep",
+	EInputTooLong:                "input too long",
+	EDuplicateKey:                "duplicate key",
+	ELeadingZero:                 "leading zero",
+	EUnknownField:                "unknown field",
+}
+
+// Error returns the description of the error type.
+func (t ErrType) Error() string {
+	if name, ok := errTypeNames[t]; ok {
+		return "rison: " + name
+	}
+	return fmt.Sprintf("rison: error type %d", int(t))
+}
@@ -0,0 +1,78 @@
+package rison
+
+import "strconv"
+
+// EventHandler receives the events of DecodeEvents in the order of the
+// values in the input, in the SAX style.
+type EventHandler interface {
+	// OnObjectStart and OnObjectEnd are called at the start and the end
+	// of an object.
+	OnObjectStart()
+	OnObjectEnd()
+	// OnArrayStart and OnArrayEnd are called at the start and the end
+	// of an array.
+	OnArrayStart()
+	OnArrayEnd()
+	// OnKey is called with each object key before its value.
+	OnKey(key string)
+	// OnValue is called with each scalar value: nil for KindNull,
+	// a bool for KindBool, a float64 for KindNumber and a string
+	// for KindString.
+	OnValue(kind Kind, v interface{})
+}
+
+// DecodeEvents parses the Rison-encoded data and calls the methods of
+// h on the values, without building the JSON or the tree, for the
+// documents too large to be held in memory as decoded. The O-Rison and
+// the A-Rison are reported as an object and an array respectively.
+// The errors are the same as Decode; the events before the error have
+// already been reported to h.
+func DecodeEvents(data []byte, m Mode, h EventHandler) error {
+	return (&parser{Mode: m}).walk(data, eventEmitter{h})
+}
+
+// eventEmitter is a handler which calls an EventHandler.
+type eventEmitter struct {
+	h EventHandler
+}
+
+func (e eventEmitter) beginObject(start int) {
+	e.h.OnObjectStart()
+}
+
+func (e eventEmitter) endObject(end int) {
+	e.h.OnObjectEnd()
+}
+
+func (e eventEmitter) beginArray(start int) {
+	e.h.OnArrayStart()
+}
+
+func (e eventEmitter) endArray(end int) {
+	e.h.OnArrayEnd()
+}
+
+func (e eventEmitter) comma(pos int) {}
+
+func (e eventEmitter) colon(pos int) {}
+
+func (e eventEmitter) key(s []byte, start, end int) {
+	e.h.OnKey(string(s))
+}
+
+func (e eventEmitter) null(start, end int) {
+	e.h.OnValue(KindNull, nil)
+}
+
+func (e eventEmitter) boolean(v bool, start, end int) {
+	e.h.OnValue(KindBool, v)
+}
+
+func (e eventEmitter) number(j []byte, start, end int) {
+	f, _ := strconv.ParseFloat(string(j), 64) // j is always a valid JSON number
+	e.h.OnValue(KindNumber, f)
+}
+
+func (e eventEmitter) string(s []byte, start, end int) {
+	e.h.OnValue(KindString, string(s))
+}
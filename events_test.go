@@ -0,0 +1,101 @@
+package rison
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// eventRecorder records the events as strings.
+type eventRecorder struct {
+	events []string
+}
+
+func (r *eventRecorder) OnObjectStart() { r.events = append(r.events, "(") }
+func (r *eventRecorder) OnObjectEnd()   { r.events = append(r.events, ")") }
+func (r *eventRecorder) OnArrayStart()  { r.events = append(r.events, "!(") }
+func (r *eventRecorder) OnArrayEnd()    { r.events = append(r.events, "!)") }
+func (r *eventRecorder) OnKey(key string) {
+	r.events = append(r.events, "key "+key)
+}
+func (r *eventRecorder) OnValue(kind Kind, v interface{}) {
+	r.events = append(r.events, fmt.Sprintf("%d %#v", kind, v))
+}
+
+// eventCounter counts the elements of the arrays.
+type eventCounter struct {
+	depth    int
+	elements int
+}
+
+func (c *eventCounter) OnObjectStart()   { c.depth++ }
+func (c *eventCounter) OnObjectEnd()     { c.depth-- }
+func (c *eventCounter) OnArrayStart()    { c.depth++ }
+func (c *eventCounter) OnArrayEnd()      { c.depth-- }
+func (c *eventCounter) OnKey(key string) {}
+func (c *eventCounter) OnValue(kind Kind, v interface{}) {
+	if c.depth == 1 {
+		c.elements++
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	cases := []struct {
+		r    string
+		m    Mode
+		want []string
+	}{
+		{"(a:0,b:!(x,!t,!n))", Rison, []string{"(", "key a", "2 0", "key b", "!(", "3 \"x\"", "1 true", "0 <nil>", "!)", ")"}},
+		{"a:'it!'s',b:()", ORison, []string{"(", "key a", "3 \"it's\"", "key b", "(", ")", ")"}},
+		{"1.5,!(!f)", ARison, []string{"!(", "2 1.5", "!(", "1 false", "!)", "!)"}},
+		{"-1e3", Rison, []string{"2 -1000"}},
+	}
+	for _, c := range cases {
+		r := &eventRecorder{}
+		err := DecodeEvents([]byte(c.r), c.m, r)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", c.r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(r.events, c.want) {
+			t.Errorf("decoding %s : want %q, got %q", c.r, c.want, r.events)
+		}
+	}
+
+	r := &eventRecorder{}
+	err := DecodeEvents([]byte("!(1,!x)"), Rison, r)
+	if _, want := Decode([]byte("!(1,!x)"), Rison); !reflect.DeepEqual(err, want) {
+		t.Errorf("decoding !(1,!x) : want %#v, got %#v", want, err)
+	}
+	if want := []string{"!(", "2 1"}; !reflect.DeepEqual(r.events, want) {
+		t.Errorf("decoding !(1,!x) : want the events %q before the error, got %q", want, r.events)
+	}
+}
+
+func TestDecodeEventsAllocations(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("!(")
+	for i := 0; i < 1000; i++ {
+		if 0 < i {
+			b.WriteByte(',')
+		}
+		b.WriteString("!t")
+	}
+	b.WriteString(")")
+	data := []byte(b.String())
+
+	c := &eventCounter{}
+	if err := DecodeEvents(data, Rison, c); err != nil || c.elements != 1000 {
+		t.Fatalf("counting the elements : want 1000, got %d, %v", c.elements, err)
+	}
+	events := testing.AllocsPerRun(10, func() {
+		_ = DecodeEvents(data, Rison, &eventCounter{})
+	})
+	tree := testing.AllocsPerRun(10, func() {
+		_, _ = Decode(data, Rison)
+	})
+	if 10 < events || tree <= events {
+		t.Errorf("counting the elements : want fewer allocations than %v of Decode, got %v", tree, events)
+	}
+}
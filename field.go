@@ -0,0 +1,51 @@
+package rison
+
+import (
+	"encoding/json"
+)
+
+// DecodeEmbedded decodes the Rison embedded in a JSON document as
+// a string. jsonString is the JSON-encoded string literal including
+// the double quotes, such as "\"(type:x)\"", which is unescaped
+// before decoding.
+func DecodeEmbedded(jsonString string, m Mode) (interface{}, error) {
+	var s string
+	err := json.Unmarshal([]byte(jsonString), &s)
+	if err != nil {
+		return nil, err
+	}
+	return Decode([]byte(s), m)
+}
+
+// Field is a value written as a Rison string in a JSON document,
+// such as the value of "filter" in {"filter":"(type:x)"}.
+//
+// A struct field of type Field is decoded by "encoding/json" from
+// the Rison in the JSON string, and encoded back to a JSON string
+// holding its Rison encoding. The Rison is always in the Rison mode.
+type Field struct {
+	Value interface{}
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (f *Field) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		f.Value = nil
+		return nil
+	}
+	v, err := DecodeEmbedded(string(data), Rison)
+	if err != nil {
+		return err
+	}
+	f.Value = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (f Field) MarshalJSON() ([]byte, error) {
+	r, err := Marshal(f.Value, Rison)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(r))
+}
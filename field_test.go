@@ -0,0 +1,57 @@
+package rison
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeEmbedded(t *testing.T) {
+	j := `"(name:'it!'s',path:'a\\b',type:x)"`
+	v, err := DecodeEmbedded(j, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", j, err.Error())
+	}
+	want := map[string]interface{}{"name": "it's", "path": `a\b`, "type": "x"}
+	if !reflect.DeepEqual(want, v) {
+		t.Errorf("decoding %s : want %s, got %s", j, dumpValue(want), dumpValue(v))
+	}
+
+	for _, j := range []string{`(type:x)`, `"(type:x"`} {
+		if _, err := DecodeEmbedded(j, Rison); err == nil {
+			t.Errorf("decoding %s : want an error, got nil", j)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	type request struct {
+		Name   string `json:"name"`
+		Filter Field  `json:"filter"`
+		Sort   *Field `json:"sort"`
+	}
+	j := `{"name":"n","filter":"(ids:!(1,2),type:x)","sort":null}`
+	var v request
+	err := json.Unmarshal([]byte(j), &v)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", j, err.Error())
+	}
+	want := map[string]interface{}{"ids": []interface{}{1.0, 2.0}, "type": "x"}
+	if v.Name != "n" || !reflect.DeepEqual(want, v.Filter.Value) || v.Sort != nil {
+		t.Errorf("decoding %s : got %+v", j, v)
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != j {
+		t.Errorf("encoding %+v : want %s, got %s", v, j, string(encoded))
+	}
+
+	j = `{"filter":"(type:x"}`
+	err = json.Unmarshal([]byte(j), &v)
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("decoding %s : want *ParseError, got %#v", j, err)
+	}
+}
@@ -0,0 +1,232 @@
+package rison
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FieldDoc describes a struct field encoded as an object key.
+type FieldDoc struct {
+	// Key is the object key of the field.
+	Key string
+	// Type is the Go type of the field.
+	Type reflect.Type
+	// OmitEmpty reports whether the field has the "omitempty" option.
+	OmitEmpty bool
+	// OmitZero reports whether the field has the "omitzero" option.
+	OmitZero bool
+}
+
+// DescribeStruct returns the descriptions of the fields of the struct
+// v (or the struct pointed to by v) in the order of the declaration.
+// It returns nil if v is not a struct.
+//
+// The fields are resolved in the same way as Marshal and Unmarshal:
+// by the struct tag "json", and the fields of embedded structs are
+// promoted as in "encoding/json".
+func DescribeStruct(v interface{}) []FieldDoc {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := structFields(t)
+	docs := make([]FieldDoc, len(fields))
+	for i, f := range fields {
+		docs[i] = FieldDoc{Key: f.name, Type: f.typ, OmitEmpty: f.omitEmpty, OmitZero: f.omitZero}
+	}
+	return docs
+}
+
+// structField is a field of a struct encoded as an object key.
+type structField struct {
+	name      string
+	tagged    bool
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	omitZero  bool
+	quoted    bool
+	rest      bool // a key in the rest field, with index nil
+}
+
+// structFields returns the fields of the struct type t to be encoded,
+// following the rules of "encoding/json".
+func structFields(t reflect.Type) []structField {
+	current := []structField{}
+	next := []structField{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	fields := []structField{}
+
+	// the numbers of the embedded structs queued at the current and the next level
+	var count, nextCount map[reflect.Type]int
+	for 0 < len(next) {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+				if isRestField(sf) {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+				if !isValidTag(name) {
+					name = ""
+				}
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					quoted := false
+					if hasOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64,
+							reflect.String:
+							quoted = true
+						}
+					}
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					fields = append(fields, structField{
+						name:      name,
+						tagged:    tagged,
+						index:     index,
+						typ:       sf.Type,
+						omitEmpty: hasOption(opts, "omitempty"),
+						omitZero:  hasOption(opts, "omitzero"),
+						quoted:    quoted,
+					})
+					if 1 < count[f.typ] {
+						// the struct appears more than once at the same level,
+						// so its fields annihilate each other by the duplicate.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, structField{name: ft.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		x := fields
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return lessIndex(x[i].index, x[j].index)
+	})
+
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		if f, ok := dominantField(fields[i:j]); ok {
+			out = append(out, f)
+		}
+		i = j
+	}
+	fields = out
+
+	sort.Slice(fields, func(i, j int) bool {
+		return lessIndex(fields[i].index, fields[j].index)
+	})
+	return fields
+}
+
+// dominantField returns the field to be encoded among the fields of
+// the same name sorted by the depth and the tag. It reports false if
+// there is no single such field.
+func dominantField(fields []structField) (structField, bool) {
+	if 1 < len(fields) && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return structField{}, false
+	}
+	return fields[0], true
+}
+
+func lessIndex(a, b []int) bool {
+	for k, x := range a {
+		if len(b) <= k {
+			return false
+		}
+		if x != b[k] {
+			return x < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func parseTag(tag string) (string, string) {
+	if i := strings.IndexByte(tag, ','); 0 <= i {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+func hasOption(opts, name string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = strings.Cut(opts, ",")
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
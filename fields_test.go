@@ -0,0 +1,63 @@
+package rison
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDescribeStruct(t *testing.T) {
+	docs := DescribeStruct(&testStruct{})
+	want := []struct {
+		key string
+		typ string
+	}{
+		{"i", "int64"},
+		{"f", "float64"},
+		{"s", "string"},
+		{"b", "bool"},
+		{"p", "*bool"},
+		{"a", "[]int64"},
+		{"x", "interface {}"},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("describing testStruct : want %d fields, got %+v", len(want), docs)
+	}
+	for i, w := range want {
+		if docs[i].Key != w.key || docs[i].Type.String() != w.typ || docs[i].OmitEmpty {
+			t.Errorf("describing testStruct : want %s %s at [%d], got %+v", w.key, w.typ, i, docs[i])
+		}
+	}
+
+	type Embedded struct {
+		ID      int    `json:"id"`
+		Shadow  string `json:"name"`
+		Created time.Time
+	}
+	type params struct {
+		Embedded
+		Name    string `json:"name,omitempty"`
+		Ignored int    `json:"-"`
+		Dash    int    `json:"-,"`
+		private int
+		Limit   int `json:",omitempty"`
+	}
+	got := DescribeStruct(params{})
+	wantDocs := []FieldDoc{
+		{Key: "id", Type: reflect.TypeOf(0)},
+		{Key: "Created", Type: reflect.TypeOf(time.Time{})},
+		{Key: "name", Type: reflect.TypeOf(""), OmitEmpty: true},
+		{Key: "-", Type: reflect.TypeOf(0)},
+		{Key: "Limit", Type: reflect.TypeOf(0), OmitEmpty: true},
+	}
+	if !reflect.DeepEqual(wantDocs, got) {
+		t.Errorf("describing params : want %+v, got %+v", wantDocs, got)
+	}
+
+	if docs := DescribeStruct(map[string]int{}); docs != nil {
+		t.Errorf("describing map : want nil, got %+v", docs)
+	}
+	if docs := DescribeStruct(nil); docs != nil {
+		t.Errorf("describing nil : want nil, got %+v", docs)
+	}
+}
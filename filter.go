@@ -0,0 +1,80 @@
+package rison
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Predicate is a condition in a filter decoded by DecodeFilter.
+type Predicate struct {
+	// Field is the key without the operator suffix.
+	Field string
+
+	// Op is the comparison operator: "EQ", "GT", "GE", "LT" or "LE".
+	Op string
+
+	// Not reports whether the condition is negated with "~".
+	Not bool
+
+	// Value is the value decoded as Decode does. An array means any of
+	// its elements.
+	Value interface{}
+}
+
+// filterOps are the operators which can be written after the fields.
+var filterOps = []string{"GT", "GE", "LT", "LE"}
+
+// DecodeFilter parses an object of a filter dialect, encoded either in
+// the Rison or in the O-Rison as DecodeAutoObject accepts, and returns
+// the predicates in the order of the keys.
+//
+// Each key is a field optionally followed by an operator suffix:
+//
+//	key    = field [ "-" op | "~" [ op ] ]
+//	op     = "GT" | "GE" | "LT" | "LE"
+//
+// A field without an operator is compared by "EQ", and "~" negates the
+// comparison. For example, size_gib-GE:100 is {size_gib GE false 100},
+// tags~:!(deprecated,dev) is {tags EQ true [deprecated dev]} and
+// size_gib~GE:1024 is {size_gib GE true 1024}. A key with a "-" not
+// followed by an operator, such as plan-id, is a field as a whole.
+func DecodeFilter(data []byte) ([]Predicate, error) {
+	m := ORison
+	if bytes.HasPrefix(data, []byte("(")) {
+		m = Rison
+	}
+	n, err := ParseNode(data, m)
+	if err != nil {
+		return nil, err
+	}
+	ps := make([]Predicate, len(n.Keys))
+	for i, key := range n.Keys {
+		p, err := parseFilterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		p.Value = n.Children[i].value()
+		ps[i] = p
+	}
+	return ps, nil
+}
+
+// parseFilterKey parses the field and the operator in the key of a filter.
+func parseFilterKey(key string) (Predicate, error) {
+	p := Predicate{Field: key, Op: "EQ"}
+	for _, op := range filterOps {
+		if strings.HasSuffix(key, "-"+op) {
+			p.Field, p.Op = key[:len(key)-len(op)-1], op
+		} else if strings.HasSuffix(key, "~"+op) {
+			p.Field, p.Op, p.Not = key[:len(key)-len(op)-1], op, true
+		}
+	}
+	if p.Op == "EQ" && strings.HasSuffix(key, "~") {
+		p.Field, p.Not = key[:len(key)-1], true
+	}
+	if p.Field == "" {
+		return p, fmt.Errorf(`no field in the filter key "%s"`, key)
+	}
+	return p, nil
+}
@@ -0,0 +1,50 @@
+package rison
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeFilter(t *testing.T) {
+	r := "name:hoge,plan:!(1,2,3),availability~:disabled,size_gib-GE:100,size_gib~GE:1024,size_gib-LT:4096,tags:stable,tags~:!(deprecated,dev),plan-id:x,'a b-LE':!n"
+	want := []Predicate{
+		{Field: "name", Op: "EQ", Value: "hoge"},
+		{Field: "plan", Op: "EQ", Value: []interface{}{float64(1), float64(2), float64(3)}},
+		{Field: "availability", Op: "EQ", Not: true, Value: "disabled"},
+		{Field: "size_gib", Op: "GE", Value: float64(100)},
+		{Field: "size_gib", Op: "GE", Not: true, Value: float64(1024)},
+		{Field: "size_gib", Op: "LT", Value: float64(4096)},
+		{Field: "tags", Op: "EQ", Value: "stable"},
+		{Field: "tags", Op: "EQ", Not: true, Value: []interface{}{"deprecated", "dev"}},
+		{Field: "plan-id", Op: "EQ", Value: "x"},
+		{Field: "a b", Op: "LE", Value: nil},
+	}
+	for _, data := range []string{r, "(" + r + ")"} {
+		ps, err := DecodeFilter([]byte(data))
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", data, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(ps, want) {
+			t.Errorf("decoding %s : want %+v, got %+v", data, want, ps)
+		}
+	}
+
+	ps, err := DecodeFilter([]byte("(size-GT:(a:1),size-GT:2)"))
+	want = []Predicate{
+		{Field: "size", Op: "GT", Value: map[string]interface{}{"a": float64(1)}},
+		{Field: "size", Op: "GT", Value: float64(2)},
+	}
+	if err != nil || !reflect.DeepEqual(ps, want) {
+		t.Errorf("decoding the duplicated keys : want %+v, got %+v, %v", want, ps, err)
+	}
+	if ps, err := DecodeFilter(nil); err != nil || len(ps) != 0 {
+		t.Errorf("decoding the empty filter : want no predicates, got %+v, %v", ps, err)
+	}
+
+	for _, data := range []string{"name:hoge,", "(a:1", "-GE:1", "~:1", "!(a)", "'a:1'"} {
+		if ps, err := DecodeFilter([]byte(data)); err == nil {
+			t.Errorf("decoding %s : want error, got %+v", data, ps)
+		}
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/sakura-internet/go-rison/v4
 
-go 1.14
+go 1.18
@@ -0,0 +1,213 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// handler receives the values recognized by the parser in order.
+//
+// The positions passed to the methods are the indexes in the input
+// wrapped by the mode: start is the index of the first character of
+// the value, and end is the index next to the last character.
+type handler interface {
+	beginObject(start int)
+	endObject(end int)
+	beginArray(start int)
+	endArray(end int)
+	comma(pos int)
+	colon(pos int)
+	key(s []byte, start, end int)
+	null(start, end int)
+	boolean(v bool, start, end int)
+	// number receives the number in the shortest JSON form,
+	// or as written with UseNumber.
+	number(j []byte, start, end int)
+	string(s []byte, start, end int)
+}
+
+// discard is a handler which drops all the values.
+type discard struct{}
+
+func (discard) beginObject(start int)           {}
+func (discard) endObject(end int)               {}
+func (discard) beginArray(start int)            {}
+func (discard) endArray(end int)                {}
+func (discard) comma(pos int)                   {}
+func (discard) colon(pos int)                   {}
+func (discard) key(s []byte, start, end int)    {}
+func (discard) null(start, end int)             {}
+func (discard) boolean(v bool, start, end int)  {}
+func (discard) number(j []byte, start, end int) {}
+func (discard) string(s []byte, start, end int) {}
+
+// valuePos maps the offset of a value in the JSON output
+// to the index of the value in the Rison input.
+type valuePos struct {
+	json  int
+	rison int
+}
+
+// jsonWriter is a handler which writes the values as JSON.
+type jsonWriter struct {
+	buffer          *bytes.Buffer
+	recordPositions bool
+	positions       []valuePos
+}
+
+func (w *jsonWriter) record(start int) {
+	if w.recordPositions {
+		w.positions = append(w.positions, valuePos{json: w.buffer.Len(), rison: start})
+	}
+}
+
+func (w *jsonWriter) beginObject(start int) {
+	w.record(start)
+	w.buffer.WriteByte('{')
+}
+
+func (w *jsonWriter) endObject(end int) {
+	w.buffer.WriteByte('}')
+}
+
+func (w *jsonWriter) beginArray(start int) {
+	w.record(start)
+	w.buffer.WriteByte('[')
+}
+
+func (w *jsonWriter) endArray(end int) {
+	w.buffer.WriteByte(']')
+}
+
+func (w *jsonWriter) comma(pos int) {
+	w.buffer.WriteByte(',')
+}
+
+func (w *jsonWriter) colon(pos int) {
+	w.buffer.WriteByte(':')
+}
+
+func (w *jsonWriter) key(s []byte, start, end int) {
+	w.string(s, start, end)
+}
+
+func (w *jsonWriter) null(start, end int) {
+	w.record(start)
+	w.buffer.WriteString("null")
+}
+
+func (w *jsonWriter) boolean(v bool, start, end int) {
+	w.record(start)
+	if v {
+		w.buffer.WriteString("true")
+	} else {
+		w.buffer.WriteString("false")
+	}
+}
+
+func (w *jsonWriter) number(j []byte, start, end int) {
+	w.record(start)
+	w.buffer.Write(j)
+}
+
+func (w *jsonWriter) string(s []byte, start, end int) {
+	w.record(start)
+	j, _ := json.Marshal(string(s)) // never fails for a string
+	w.buffer.Write(j)
+}
+
+// treeBuilder is a handler which builds the tree of
+// map[string]interface{}, []interface{} and scalar values,
+// as json.Unmarshal into interface{} does.
+type treeBuilder struct {
+	root      interface{}
+	stack     []*treeFrame
+	useNumber bool
+	intern    bool
+}
+
+// internedNumbers are the shared interface{} values of the integers
+// from 0 to 255 for InternCommonValues.
+var internedNumbers = func() (a [256]interface{}) {
+	for i := range a {
+		a[i] = float64(i)
+	}
+	return
+}()
+
+// treeFrame is an array or an object being built.
+type treeFrame struct {
+	object map[string]interface{}
+	array  []interface{}
+	key    string
+}
+
+func (b *treeBuilder) add(v interface{}) {
+	if len(b.stack) == 0 {
+		b.root = v
+		return
+	}
+	top := b.stack[len(b.stack)-1]
+	if top.object != nil {
+		top.object[top.key] = v
+	} else {
+		top.array = append(top.array, v)
+	}
+}
+
+func (b *treeBuilder) pop() *treeFrame {
+	top := b.stack[len(b.stack)-1]
+	b.stack = b.stack[:len(b.stack)-1]
+	return top
+}
+
+func (b *treeBuilder) beginObject(start int) {
+	b.stack = append(b.stack, &treeFrame{object: map[string]interface{}{}})
+}
+
+func (b *treeBuilder) endObject(end int) {
+	b.add(b.pop().object)
+}
+
+func (b *treeBuilder) beginArray(start int) {
+	b.stack = append(b.stack, &treeFrame{array: []interface{}{}})
+}
+
+func (b *treeBuilder) endArray(end int) {
+	b.add(b.pop().array)
+}
+
+func (b *treeBuilder) comma(pos int) {}
+
+func (b *treeBuilder) colon(pos int) {}
+
+func (b *treeBuilder) key(s []byte, start, end int) {
+	b.stack[len(b.stack)-1].key = string(s)
+}
+
+func (b *treeBuilder) null(start, end int) {
+	b.add(nil)
+}
+
+func (b *treeBuilder) boolean(v bool, start, end int) {
+	b.add(v)
+}
+
+func (b *treeBuilder) number(j []byte, start, end int) {
+	if b.useNumber {
+		b.add(json.Number(j))
+		return
+	}
+	f, _ := strconv.ParseFloat(string(j), 64) // j is always a valid JSON number
+	if b.intern && 0 <= f && f < float64(len(internedNumbers)) && f == math.Trunc(f) && !math.Signbit(f) {
+		b.add(internedNumbers[int(f)])
+		return
+	}
+	b.add(f)
+}
+
+func (b *treeBuilder) string(s []byte, start, end int) {
+	b.add(string(s))
+}
@@ -0,0 +1,70 @@
+package rison
+
+import "reflect"
+
+var boolType = reflect.TypeOf(true)
+
+// iterArity returns 1 if t is a function type of the form of
+// iter.Seq[V], func(yield func(V) bool), 2 for iter.Seq2[K, V],
+// func(yield func(K, V) bool), and 0 otherwise.
+func iterArity(t reflect.Type) int {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 0 {
+		return 0
+	}
+	y := t.In(0)
+	if y.Kind() != reflect.Func || y.NumOut() != 1 || y.Out(0) != boolType {
+		return 0
+	}
+	if n := y.NumIn(); n == 1 || n == 2 {
+		return n
+	}
+	return 0
+}
+
+// encodeSeq writes the values yielded by the iter.Seq v as an array.
+func (e *encoder) encodeSeq(v reflect.Value) error {
+	var err error
+	i := 0
+	e.buffer.WriteString("!(")
+	yield := reflect.MakeFunc(v.Type().In(0), func(args []reflect.Value) []reflect.Value {
+		// an iterator ignoring false from yield must not replace the
+		// first error, nor write the values after it
+		if err != nil {
+			return []reflect.Value{reflect.ValueOf(false)}
+		}
+		if 0 < i {
+			e.buffer.WriteByte(',')
+		}
+		e.pushIndex(i)
+		err = e.encodeValue(args[0])
+		e.pop()
+		i++
+		return []reflect.Value{reflect.ValueOf(err == nil)}
+	})
+	v.Call([]reflect.Value{yield})
+	if err != nil {
+		return err
+	}
+	e.buffer.WriteByte(')')
+	return nil
+}
+
+// encodeSeq2 writes the pairs yielded by the iter.Seq2 v as an object.
+func (e *encoder) encodeSeq2(v reflect.Value) error {
+	var entries []mapEntry
+	var err error
+	yield := reflect.MakeFunc(v.Type().In(0), func(args []reflect.Value) []reflect.Value {
+		if err != nil {
+			return []reflect.Value{reflect.ValueOf(false)}
+		}
+		var en mapEntry
+		en, err = e.mapEntry(args[0], args[1])
+		entries = append(entries, en)
+		return []reflect.Value{reflect.ValueOf(err == nil)}
+	})
+	v.Call([]reflect.Value{yield})
+	if err != nil {
+		return err
+	}
+	return e.encodeEntries(entries)
+}
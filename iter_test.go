@@ -0,0 +1,81 @@
+//go:build go1.23
+
+package rison
+
+import (
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAllowIterators(t *testing.T) {
+	opts := EncodeOptions{AllowIterators: true}
+	var nilSeq iter.Seq[int]
+	cases := []struct {
+		v    interface{}
+		m    Mode
+		want string
+	}{
+		{slices.Values([]int{3, 1, 2}), Rison, "!(3,1,2)"},
+		{slices.Values([]string{"a", "b c"}), ARison, "a,'b c'"},
+		{slices.Values([]int{}), Rison, "!()"},
+		{maps.All(map[string]int{"b": 2, "a": 1}), Rison, "(a:1,b:2)"},
+		{maps.All(map[int]string{10: "x", 2: "y"}), ORison, "'10':x,'2':y"},
+		{struct {
+			IDs  iter.Seq[int]               `json:"ids"`
+			Tags iter.Seq2[string, []string] `json:"tags"`
+			None iter.Seq[int]               `json:"none"`
+		}{slices.Values([]int{1, 2}), maps.All(map[string][]string{"k": {"v"}}), nilSeq}, Rison, "(ids:!(1,2),none:!n,tags:(k:!(v)))"},
+	}
+	for _, c := range cases {
+		r, err := MarshalWithOptions(c.v, c.m, opts)
+		if err != nil {
+			t.Errorf("encoding %s : want no error, got error `%s`", c.want, err.Error())
+			continue
+		}
+		if string(r) != c.want {
+			t.Errorf("encoding : want %s, got %s", c.want, r)
+		}
+	}
+
+	taken := 0
+	nested := func(yield func(interface{}) bool) {
+		for i := 0; i < 10; i++ {
+			taken++
+			if !yield(func() {}) {
+				return
+			}
+		}
+	}
+	if _, err := MarshalWithOptions(nested, Rison, opts); err == nil || taken != 1 {
+		t.Errorf("encoding a seq of functions : want to stop at the first error, got %d, %v", taken, err)
+	}
+
+	// an iterator ignoring false from yield keeps the first error
+	ignoring := func(yield func(interface{}) bool) {
+		yield(func() {})
+		yield(1)
+	}
+	if _, err := MarshalWithOptions(ignoring, Rison, opts); err == nil {
+		t.Errorf("encoding a seq ignoring false from yield : want the first error, got nil")
+	}
+	ignoring2 := func(yield func(string, interface{}) bool) {
+		yield("a", func() {})
+		yield("b", 1)
+	}
+	if _, err := MarshalWithOptions(ignoring2, Rison, opts); err == nil {
+		t.Errorf("encoding a seq2 ignoring false from yield : want the first error, got nil")
+	}
+
+	if _, err := Marshal(slices.Values([]int{1}), Rison); err == nil || !strings.Contains(err.Error(), "func") {
+		t.Errorf("encoding a seq without the option : want an error, got %v", err)
+	}
+	if _, err := MarshalWithOptions(slices.Values([]int{1}), ORison, opts); err == nil {
+		t.Errorf("encoding a seq to the O-Rison : want an error, got nil")
+	}
+	if _, err := MarshalWithOptions(maps.All(map[string]int{"x": 1}), Rison, EncodeOptions{AllowIterators: true, AllowedKeys: func(string) bool { return false }}); err == nil {
+		t.Errorf("encoding a seq2 with a disallowed key : want an error, got nil")
+	}
+}
@@ -0,0 +1,270 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is the error returned by Lookup when no value is found at the path.
+var ErrNotFound = errors.New("rison: value not found")
+
+// ErrNotContainer is the error returned by Get when the path goes into
+// a value which is not an array or an object as expected.
+var ErrNotContainer = errors.New("rison: value not an array or an object")
+
+// Lookup parses the Rison-encoded data and returns the value at the path,
+// such as "type" or "filters[0].op". The path is the object keys separated
+// by "." and the array indexes in "[]"; the empty path is the root.
+// If a key appears more than once in an object, the last one is used.
+//
+// The whole data is validated, but only the value at the path is decoded.
+// If there is no value at the path, the error wraps ErrNotFound.
+func Lookup(data []byte, path string, m Mode) (interface{}, error) {
+	target, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &lookup{target: target}
+	err = (&parser{Mode: m}).walk(data, l)
+	if err != nil {
+		return nil, err
+	}
+	if l.found == nil {
+		return nil, fmt.Errorf("%w at %s", ErrNotFound, path)
+	}
+	var v interface{}
+	err = json.Unmarshal(l.found, &v)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Get decodes the Rison-encoded data and returns the value at the path,
+// in the same form as Lookup. Unlike Lookup, the error tells why there
+// is no value at the path: it wraps ErrNotFound for a missing key or an
+// index out of range, and ErrNotContainer for a key of a value other
+// than an object or an index of a value other than an array, such as
+// a[0] for (a:1).
+func Get(data []byte, path string, m Mode) (interface{}, error) {
+	elems, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := Decode(data, m)
+	if err != nil {
+		return nil, err
+	}
+	at := "$"
+	for _, e := range elems {
+		if e.index < 0 {
+			o, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w at %s for the key %s", ErrNotContainer, at, e.key)
+			}
+			at += jsonPathKey(e.key)
+			v, ok = o[e.key]
+			if !ok {
+				return nil, fmt.Errorf("%w at %s", ErrNotFound, at)
+			}
+			continue
+		}
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w at %s for the index %d", ErrNotContainer, at, e.index)
+		}
+		at += fmt.Sprintf("[%d]", e.index)
+		if len(a) <= e.index {
+			return nil, fmt.Errorf("%w at %s", ErrNotFound, at)
+		}
+		v = a[e.index]
+	}
+	return v, nil
+}
+
+// Extract parses the Rison-encoded data and returns the Rison encoding
+// of the value at the path, as Lookup finds it, such as !(1,2,3) for the
+// path "baz" in (foo:bar,baz:!(1,2,3)). The value is sliced out of the
+// data as it is written, without decoding and encoding it again.
+//
+// The result is always in the Rison mode; the root of O-Rison or
+// A-Rison data is extracted with the parentheses.
+func Extract(data []byte, path string, m Mode) ([]byte, error) {
+	target, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &lookup{target: target}
+	p := &parser{Mode: m}
+	err = p.walk(data, l)
+	if err != nil {
+		return nil, err
+	}
+	if l.found == nil {
+		return nil, fmt.Errorf("%w at %s", ErrNotFound, path)
+	}
+	return append([]byte(nil), p.string[l.start:l.stop]...), nil
+}
+
+// parsePath parses the path given to Lookup.
+func parsePath(path string) ([]pathElem, error) {
+	var elems []pathElem
+	s := path
+	for s != "" {
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf(`missing "]" in path %s`, path)
+			}
+			i, err := strconv.Atoi(s[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid index %s in path %s", s[1:end], path)
+			}
+			elems = append(elems, pathElem{index: i})
+			s = s[end+1:]
+			continue
+		}
+		if 0 < len(elems) {
+			if s[0] != '.' {
+				return nil, fmt.Errorf(`missing "." in path %s`, path)
+			}
+			s = s[1:]
+		}
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("empty key in path %s", path)
+		}
+		elems = append(elems, pathElem{key: s[:end], index: -1})
+		s = s[end:]
+	}
+	return elems, nil
+}
+
+// lookup is a handler which captures the JSON encoding of the value
+// at the target path.
+type lookup struct {
+	target []pathElem
+	stack  []pathElem
+	w      *jsonWriter
+	depth  int
+	found  []byte
+	start  int // the position of the value being captured
+	stop   int // the end of the found value
+}
+
+// begin starts capturing if the value being started is at the target.
+func (l *lookup) begin(start int) {
+	if l.w != nil || len(l.stack) != len(l.target) {
+		return
+	}
+	for i, e := range l.stack {
+		if e != l.target[i] {
+			return
+		}
+	}
+	l.w = &jsonWriter{buffer: &bytes.Buffer{}}
+	l.depth = len(l.stack)
+	l.start = start
+}
+
+// end finishes capturing if the value at the target is ended.
+func (l *lookup) end(end int) {
+	if l.w != nil && len(l.stack) == l.depth {
+		l.found = l.w.buffer.Bytes()
+		l.stop = end
+		l.w = nil
+	}
+}
+
+func (l *lookup) beginObject(start int) {
+	l.begin(start)
+	if l.w != nil {
+		l.w.beginObject(start)
+	}
+	l.stack = append(l.stack, pathElem{index: -1})
+}
+
+func (l *lookup) endObject(end int) {
+	l.stack = l.stack[:len(l.stack)-1]
+	if l.w != nil {
+		l.w.endObject(end)
+	}
+	l.end(end)
+}
+
+func (l *lookup) beginArray(start int) {
+	l.begin(start)
+	if l.w != nil {
+		l.w.beginArray(start)
+	}
+	l.stack = append(l.stack, pathElem{index: 0})
+}
+
+func (l *lookup) endArray(end int) {
+	l.stack = l.stack[:len(l.stack)-1]
+	if l.w != nil {
+		l.w.endArray(end)
+	}
+	l.end(end)
+}
+
+func (l *lookup) comma(pos int) {
+	if top := &l.stack[len(l.stack)-1]; 0 <= top.index {
+		top.index++
+	}
+	if l.w != nil {
+		l.w.comma(pos)
+	}
+}
+
+func (l *lookup) colon(pos int) {
+	if l.w != nil {
+		l.w.colon(pos)
+	}
+}
+
+func (l *lookup) key(s []byte, start, end int) {
+	l.stack[len(l.stack)-1].key = string(s)
+	if l.w != nil {
+		l.w.key(s, start, end)
+	}
+}
+
+func (l *lookup) null(start, end int) {
+	l.begin(start)
+	if l.w != nil {
+		l.w.null(start, end)
+	}
+	l.end(end)
+}
+
+func (l *lookup) boolean(v bool, start, end int) {
+	l.begin(start)
+	if l.w != nil {
+		l.w.boolean(v, start, end)
+	}
+	l.end(end)
+}
+
+func (l *lookup) number(j []byte, start, end int) {
+	l.begin(start)
+	if l.w != nil {
+		l.w.number(j, start, end)
+	}
+	l.end(end)
+}
+
+func (l *lookup) string(s []byte, start, end int) {
+	l.begin(start)
+	if l.w != nil {
+		l.w.string(s, start, end)
+	}
+	l.end(end)
+}
@@ -0,0 +1,166 @@
+package rison
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	r := "(type:range,filters:!((op:eq,v:1),(op:gt,v:!(2,3)),!(x,y)),meta:(a:(b:'c d')),dup:1,dup:2)"
+	cases := map[string]interface{}{
+		"type":              "range",
+		"filters[0].op":     "eq",
+		"filters[1]":        map[string]interface{}{"op": "gt", "v": []interface{}{float64(2), float64(3)}},
+		"filters[1].v[1]":   float64(3),
+		"filters[2][1]":     "y",
+		"meta.a.b":          "c d",
+		"meta.a":            map[string]interface{}{"b": "c d"},
+		"dup":               float64(2),
+		"filters[0].v":      float64(1),
+		"filters[1].v[0]":   float64(2),
+		"meta":              map[string]interface{}{"a": map[string]interface{}{"b": "c d"}},
+		"filters[2]":        []interface{}{"x", "y"},
+		"filters[1].op":     "gt",
+		"filters[2][0]":     "x",
+		"filters[0]":        map[string]interface{}{"op": "eq", "v": float64(1)},
+		"meta.a.b.c":        nil,
+		"filters[3]":        nil,
+		"filters.op":        nil,
+		"type[0]":           nil,
+		"missing":           nil,
+		"filters[0].op.x":   nil,
+		"filters[1].v[2]":   nil,
+		"filters[0].missed": nil,
+	}
+	for path, want := range cases {
+		v, err := Lookup([]byte(r), path, Rison)
+		if want == nil {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("looking up %s : want ErrNotFound, got %s, %v", path, dumpValue(v), err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("looking up %s : want no error, got error `%s`", path, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("looking up %s : want %s, got %s", path, dumpValue(want), dumpValue(v))
+		}
+	}
+
+	v, err := Lookup([]byte("a:1,b:!(!n,x)"), "b[0]", ORison)
+	if err != nil || v != nil {
+		t.Errorf("looking up b[0] : want nil, got %s, %v", dumpValue(v), err)
+	}
+	v, err = Lookup([]byte("a,!(b,c)"), "[1][0]", ARison)
+	if err != nil || v != "b" {
+		t.Errorf("looking up [1][0] : want b, got %s, %v", dumpValue(v), err)
+	}
+	v, err = Lookup([]byte("(a:1)"), "", Rison)
+	if err != nil || !reflect.DeepEqual(v, map[string]interface{}{"a": float64(1)}) {
+		t.Errorf("looking up the root : want (a:1), got %s, %v", dumpValue(v), err)
+	}
+
+	if _, err := Lookup([]byte("(type:a,x:!(1,)"), "type", Rison); err == nil {
+		t.Errorf("want an error for invalid Rison after the value")
+	}
+	for _, path := range []string{"a..b", "a[", "a[x]", "a[-1]", "[0]b", ".a"} {
+		if _, err := Lookup([]byte("(a:1)"), path, Rison); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("looking up %s : want an error for the invalid path, got %v", path, err)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	r := "(a:(b:!(x,y,(c:1))),s:str)"
+	cases := map[string]interface{}{
+		"":         map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x", "y", map[string]interface{}{"c": float64(1)}}}, "s": "str"},
+		"a.b[1]":   "y",
+		"a.b[2].c": float64(1),
+		"s":        "str",
+	}
+	for path, want := range cases {
+		v, err := Get([]byte(r), path, Rison)
+		if err != nil {
+			t.Errorf("getting %s : want no error, got error `%s`", path, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("getting %s : want %s, got %s", path, dumpValue(want), dumpValue(v))
+		}
+	}
+
+	errs := map[string]error{
+		"missing":    ErrNotFound,
+		"a.b[3]":     ErrNotFound,
+		"a.b[2].d":   ErrNotFound,
+		"s[0]":       ErrNotContainer,
+		"s.x":        ErrNotContainer,
+		"a.b.c":      ErrNotContainer,
+		"a[0]":       ErrNotContainer,
+		"a.b[2].c.d": ErrNotContainer,
+	}
+	for path, want := range errs {
+		v, err := Get([]byte(r), path, Rison)
+		if !errors.Is(err, want) {
+			t.Errorf("getting %s : want %v, got %s, %v", path, want, dumpValue(v), err)
+		}
+	}
+	_, err := Get([]byte(r), "a.b[2].d", Rison)
+	if err == nil || err.Error() != "rison: value not found at $.a.b[2].d" {
+		t.Errorf("getting a.b[2].d : want the error with the path, got %v", err)
+	}
+	_, err = Get([]byte(r), "s[0]", Rison)
+	if err == nil || err.Error() != "rison: value not an array or an object at $.s for the index 0" {
+		t.Errorf("getting s[0] : want the error with the path, got %v", err)
+	}
+	_, err = Get([]byte("(a:"), "a", Rison)
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("getting a of (a: : want *ParseError, got %v", err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	r := "(foo:bar,baz:!(1,2,3),q:'a b',o:(x:!t,y:!(!n,(z:-1.5e3))),dup:1,dup:!(2))"
+	cases := map[string]string{
+		"baz":      "!(1,2,3)",
+		"baz[1]":   "2",
+		"foo":      "bar",
+		"q":        "'a b'",
+		"o":        "(x:!t,y:!(!n,(z:-1.5e3)))",
+		"o.x":      "!t",
+		"o.y":      "!(!n,(z:-1.5e3))",
+		"o.y[0]":   "!n",
+		"o.y[1].z": "-1.5e3",
+		"dup":      "!(2)",
+		"":         r,
+	}
+	for path, want := range cases {
+		got, err := Extract([]byte(r), path, Rison)
+		if err != nil {
+			t.Errorf("extracting %s : want no error, got error `%s`", path, err.Error())
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("extracting %s : want %s, got %s", path, want, got)
+		}
+	}
+
+	for _, path := range []string{"missing", "baz[3]", "foo[0]", "o.y[1].w"} {
+		got, err := Extract([]byte(r), path, Rison)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("extracting %s : want ErrNotFound, got %s, %v", path, got, err)
+		}
+	}
+
+	got, err := Extract([]byte("a:1,b:!(x)"), "", ORison)
+	if err != nil || string(got) != "(a:1,b:!(x))" {
+		t.Errorf("extracting the root of O-Rison : want (a:1,b:!(x)), got %s, %v", got, err)
+	}
+	got, err = Extract([]byte("a,(b:c)"), "[1]", ARison)
+	if err != nil || string(got) != "(b:c)" {
+		t.Errorf("extracting [1] of A-Rison : want (b:c), got %s, %v", got, err)
+	}
+}
@@ -0,0 +1,227 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into valid Rison, such as !(35.68,139.77) for a pair of
+// coordinates, instead of the default encoding of their kinds.
+// MarshalRison is preferred to MarshalJSON and MarshalText.
+type Marshaler interface {
+	MarshalRison() ([]byte, error)
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal
+// a Rison description of themselves. UnmarshalRison receives the value
+// in the canonical Rison, with the object keys sorted and the numbers
+// and strings in their shortest forms, and !n for null. It must copy
+// the data if it wishes to retain the data after returning.
+// UnmarshalRison is preferred to UnmarshalJSON and UnmarshalText.
+type Unmarshaler interface {
+	UnmarshalRison([]byte) error
+}
+
+// RawMessage is a Rison-encoded value, like json.RawMessage. As a
+// field of a struct, it keeps the value undecoded by Unmarshal, to be
+// decoded later such as after looking at another field telling its
+// type, and it is written as it is by Marshal. Unmarshal stores the
+// value sliced out of the data as it is written, as Extract does,
+// rather than in the canonical Rison other Unmarshalers receive.
+type RawMessage []byte
+
+// MarshalRison returns m, or !n if m is nil.
+func (m RawMessage) MarshalRison() ([]byte, error) {
+	if m == nil {
+		return []byte("!n"), nil
+	}
+	return m, nil
+}
+
+// UnmarshalRison sets *m to a copy of data.
+func (m *RawMessage) UnmarshalRison(data []byte) error {
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// unmarshalsRison reports whether a value of type t, which is not a
+// pointer, is decoded by its own UnmarshalRison method.
+func unmarshalsRison(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(unmarshalerType)
+}
+
+// needsRisonUnmarshaler reports whether a value of type t can hold
+// a value decoded by UnmarshalRison.
+func needsRisonUnmarshaler(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	if t.Kind() == reflect.Ptr {
+		return needsRisonUnmarshaler(t.Elem(), seen)
+	}
+	if unmarshalsRison(t) {
+		return true
+	}
+	if decodesItself(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return needsRisonUnmarshaler(t.Elem(), seen)
+	case reflect.Struct:
+		for _, f := range structFields(t) {
+			if needsRisonUnmarshaler(f.typ, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fillRison calls UnmarshalRison of the values in the value pointed to
+// by v, which the JSON-encoded data j has been decoded into with such
+// values replaced with null by rewriteJSON.
+func (p *parser) fillRison(j []byte, v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr || !needsRisonUnmarshaler(t, map[reflect.Type]bool{}) {
+		return nil
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var tree interface{}
+	err := d.Decode(&tree)
+	if err != nil {
+		return err
+	}
+	n, err := p.sourceNode()
+	if err != nil {
+		return err
+	}
+	return setRison(tree, n, reflect.ValueOf(v))
+}
+
+// sourceNode returns the tree of nodes of the input parsed by p, with
+// the offsets in the input wrapped by the mode, for RawMessage to slice
+// the values out of it.
+func (p *parser) sourceNode() (*Node, error) {
+	opts := p.DecodeOptions
+	opts.MaxInputBytes = 0 // the wrapped input may be longer than the limit
+	b := &nodeBuilder{src: p.string, mode: Rison}
+	err := (&parser{Mode: Rison, DecodeOptions: opts}).walk(p.string, b)
+	if err != nil {
+		return nil, err
+	}
+	return b.root, nil
+}
+
+// setRison calls UnmarshalRison of the values in v with the
+// corresponding values in the decoded tree, and RawMessage with the
+// source of the node n.
+func setRison(tree interface{}, n *Node, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr {
+		if tree == nil {
+			return nil
+		}
+		if v.IsNil() {
+			// the pointer to a value decoded by UnmarshalRison is left
+			// nil for the null written by rewriteJSON
+			if !v.CanSet() || !needsRisonUnmarshaler(v.Type(), map[reflect.Type]bool{}) {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if unmarshalsRison(v.Type()) {
+		if m, ok := v.Addr().Interface().(*RawMessage); ok && n != nil {
+			return m.UnmarshalRison(n.src[n.Start:n.End])
+		}
+		r, err := EncodeTree(tree, Rison)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalRison(r)
+	}
+	if decodesItself(v.Type()) {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		a, _ := tree.([]interface{})
+		for i := 0; i < len(a) && i < v.Len(); i++ {
+			err := setRison(a[i], childNode(n, i), v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		o, _ := tree.(map[string]interface{})
+		if !needsRisonUnmarshaler(v.Type().Elem(), map[reflect.Type]bool{}) {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			k, err := mapKey(key)
+			if err != nil {
+				continue
+			}
+			m, ok := o[k]
+			if !ok {
+				continue
+			}
+			// the elements of a map are not addressable
+			c := reflect.New(v.Type().Elem()).Elem()
+			c.Set(v.MapIndex(key))
+			err = setRison(m, memberNode(n, k), c)
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(key, c)
+		}
+	case reflect.Struct:
+		o, ok := tree.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, f := range structFields(v.Type()) {
+			k, ok := memberKey(o, f.name)
+			if !ok {
+				continue
+			}
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok {
+				continue
+			}
+			err := setRison(o[k], memberNode(n, k), fv)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// childNode returns the i-th element of the array node n, or nil if
+// there is none.
+func childNode(n *Node, i int) *Node {
+	if n == nil || n.Kind != KindArray || len(n.Children) <= i {
+		return nil
+	}
+	return n.Children[i]
+}
+
+// memberNode returns the member of the object node n for the key, or
+// nil if there is none.
+func memberNode(n *Node, key string) *Node {
+	if n == nil || n.Kind != KindObject {
+		return nil
+	}
+	return n.Get(key)
+}
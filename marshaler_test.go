@@ -0,0 +1,147 @@
+package rison
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLatLng is written as !(lat,lng) instead of an object.
+type testLatLng struct {
+	Lat, Lng float64
+}
+
+func (c testLatLng) MarshalRison() ([]byte, error) {
+	if 90 < c.Lat || c.Lat < -90 {
+		return nil, fmt.Errorf("invalid latitude %v", c.Lat)
+	}
+	return Marshal([]float64{c.Lat, c.Lng}, Rison)
+}
+
+func (c *testLatLng) UnmarshalRison(data []byte) error {
+	var a [2]float64
+	err := Unmarshal(data, &a, Rison)
+	if err != nil {
+		return err
+	}
+	c.Lat, c.Lng = a[0], a[1]
+	return nil
+}
+
+// testBrokenMarshaler writes invalid Rison.
+type testBrokenMarshaler struct{}
+
+func (testBrokenMarshaler) MarshalRison() ([]byte, error) {
+	return []byte("(a:"), nil
+}
+
+func TestMarshaler(t *testing.T) {
+	type place struct {
+		Name   string                `json:"name"`
+		At     testLatLng            `json:"at"`
+		Entry  *testLatLng           `json:"entry"`
+		Exit   *testLatLng           `json:"exit"`
+		Route  []testLatLng          `json:"route"`
+		Nearby map[string]testLatLng `json:"nearby"`
+	}
+	v := place{
+		Name:   "x",
+		At:     testLatLng{35.68, 139.77},
+		Entry:  &testLatLng{1, 2},
+		Route:  []testLatLng{{3, 4}, {-5.5, 6}},
+		Nearby: map[string]testLatLng{"a b": {7, 8}},
+	}
+	want := "(at:!(35.68,139.77),entry:!(1,2),exit:!n,name:x,nearby:('a b':!(7,8)),route:!(!(3,4),!(-5.5,6)))"
+	r, err := Marshal(v, Rison)
+	if err != nil || string(r) != want {
+		t.Fatalf("encoding %+v : want %s, got %s, %v", v, want, r, err)
+	}
+	tree, err := EncodeTree(map[string]interface{}{"at": v.At}, Rison)
+	if err != nil || string(tree) != "(at:!(35.68,139.77))" {
+		t.Errorf("encoding the tree : want (at:!(35.68,139.77)), got %s, %v", tree, err)
+	}
+	tree, err = EncodeTree(map[string]interface{}{"at": (*testLatLng)(nil)}, Rison)
+	if err != nil || string(tree) != "(at:!n)" {
+		t.Errorf("encoding the tree of a nil pointer : want (at:!n), got %s, %v", tree, err)
+	}
+
+	var decoded place
+	err = Unmarshal(r, &decoded, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if !reflect.DeepEqual(decoded, v) {
+		t.Errorf("decoding %s : want %+v, got %+v", r, v, decoded)
+	}
+
+	var c testLatLng
+	err = Unmarshal([]byte("!(1.5,-2)"), &c, Rison)
+	if err != nil || c != (testLatLng{1.5, -2}) {
+		t.Errorf("decoding !(1.5,-2) : want {1.5 -2}, got %+v, %v", c, err)
+	}
+	p, err := DecodeTo[*testLatLng]([]byte("!(3,4)"), Rison)
+	if err != nil || p == nil || *p != (testLatLng{3, 4}) {
+		t.Errorf("decoding !(3,4) into *testLatLng : want &{3 4}, got %+v, %v", p, err)
+	}
+
+	err = Unmarshal([]byte("(at:(lat:1,lng:2))"), &decoded, Rison)
+	if err == nil {
+		t.Errorf("decoding (at:(lat:1,lng:2)) : want the error of UnmarshalRison, got nil")
+	}
+	err = Unmarshal([]byte("(at:!(1,2),name:!(x))"), &decoded, Rison)
+	if e, ok := err.(*ParseError); !ok || e.Type != ETypeMismatch || e.Pos != 16 {
+		t.Errorf("decoding (at:!(1,2),name:!(x)) : want ETypeMismatch at 16, got %#v", err)
+	}
+
+	if _, err := Marshal(place{At: testLatLng{91, 0}}, Rison); err == nil || !strings.Contains(err.Error(), "invalid latitude 91") {
+		t.Errorf("encoding an invalid latitude : want the error of MarshalRison, got %v", err)
+	}
+	if _, err := Marshal(map[string]interface{}{"b": testBrokenMarshaler{}}, Rison); err == nil {
+		t.Errorf("encoding invalid Rison from MarshalRison : want an error, got nil")
+	}
+}
+
+func TestRisonRawMessage(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	type shape struct {
+		Type string     `json:"type"`
+		Data RawMessage `json:"data"`
+	}
+	var s shape
+	err := Unmarshal([]byte("(type:point,data:(y:2,x:1))"), &s, Rison)
+	if err != nil {
+		t.Fatalf("decoding (type:point,data:(y:2,x:1)) : want no error, got error `%s`", err.Error())
+	}
+	if s.Type != "point" || string(s.Data) != "(y:2,x:1)" {
+		t.Errorf("decoding (type:point,data:(y:2,x:1)) : want point and (y:2,x:1), got %s and %s", s.Type, s.Data)
+	}
+	var p point
+	if err := Unmarshal(s.Data, &p, Rison); err != nil || p != (point{1, 2}) {
+		t.Errorf("decoding %s : want {1 2}, got %+v, %v", s.Data, p, err)
+	}
+
+	r, err := Marshal(s, Rison)
+	if want := "(data:(y:2,x:1),type:point)"; err != nil || string(r) != want {
+		t.Errorf("encoding %+v : want %s, got %s, %v", s, want, r, err)
+	}
+	r, err = Marshal(shape{Type: "none"}, Rison)
+	if want := "(data:!n,type:none)"; err != nil || string(r) != want {
+		t.Errorf("encoding a nil RawMessage : want %s, got %s, %v", want, r, err)
+	}
+	if _, err := Marshal(shape{Data: RawMessage("(x:")}, Rison); err == nil {
+		t.Errorf("encoding an invalid RawMessage : want an error, got nil")
+	}
+
+	var msgs []RawMessage
+	if err := Unmarshal([]byte("!(1,'a b',!n)"), &msgs, Rison); err != nil || len(msgs) != 3 || string(msgs[1]) != "'a b'" || string(msgs[2]) != "!n" {
+		t.Errorf("decoding !(1,'a b',!n) : want the raw elements, got %q, %v", msgs, err)
+	}
+	var m RawMessage
+	if err := Unmarshal([]byte("b:1.50,a:'x'"), &m, ORison); err != nil || string(m) != "(b:1.50,a:'x')" {
+		t.Errorf("decoding b:1.50,a:'x' in the O-Rison : want (b:1.50,a:'x'), got %s, %v", m, err)
+	}
+}
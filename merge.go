@@ -0,0 +1,75 @@
+package rison
+
+import "fmt"
+
+// Merge overlays the Rison-encoded object patch on the object base and
+// returns the Rison encoding of the result in the mode m, both given in
+// the mode as well, as JSON Merge Patch (RFC 7396) does: the members of
+// the patch replace those of the base with the same keys, except that
+// the objects in both are merged recursively, and the keys of !n in the
+// patch are deleted from the base. The other values, including arrays,
+// replace the whole values in the base, such as a scalar over an object.
+//
+// It fails if base or patch is not an object. The keys of the result
+// are sorted as EncodeTree writes them.
+func Merge(base, patch []byte, m Mode) ([]byte, error) {
+	b, err := decodeObject(base, m, "base")
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeObject(patch, m, "patch")
+	if err != nil {
+		return nil, err
+	}
+	return EncodeTree(mergeObjects(b, p), m)
+}
+
+// decodeObject decodes the data which must be an object, named in the
+// error otherwise.
+func decodeObject(data []byte, m Mode, name string) (map[string]interface{}, error) {
+	v, err := Decode(data, m)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("only objects can be merged, but the %s is %s", name, dumpKind(v))
+	}
+	return o, nil
+}
+
+// dumpKind returns the kind of the decoded value v in the words of Rison.
+func dumpKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "an array"
+	case bool:
+		return "a boolean"
+	case string:
+		return "a string"
+	}
+	return "a number"
+}
+
+// mergeObjects merges the patch into the base in place and returns it.
+func mergeObjects(base, patch map[string]interface{}) map[string]interface{} {
+	for k, v := range patch {
+		if v == nil {
+			delete(base, k)
+			continue
+		}
+		po, ok := v.(map[string]interface{})
+		if !ok {
+			base[k] = v
+			continue
+		}
+		bo, ok := base[k].(map[string]interface{})
+		if !ok {
+			bo = map[string]interface{}{}
+		}
+		base[k] = mergeObjects(bo, po)
+	}
+	return base
+}
@@ -0,0 +1,55 @@
+package rison
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	cases := []struct {
+		base  string
+		patch string
+		want  string
+	}{
+		{"(a:1,b:2)", "(b:3,c:4)", "(a:1,b:3,c:4)"},
+		{"(a:(x:1,y:(p:1,q:2)),b:!(1,2))", "(a:(y:(q:3,r:4),z:5),b:!(3))", "(a:(x:1,y:(p:1,q:3,r:4),z:5),b:!(3))"},
+		{"(a:1,b:(x:1),c:3)", "(a:!n,b:(x:!n),d:!n)", "(b:(),c:3)"},
+		{"(a:(x:1))", "(a:scalar)", "(a:scalar)"},
+		{"(a:scalar)", "(a:(x:1,y:!n))", "(a:(x:1))"},
+		{"(a:!(1,(x:1)))", "(a:!((y:2)))", "(a:!((y:2)))"},
+		{"(a:1)", "()", "(a:1)"},
+		{"()", "(a:'x y')", "(a:'x y')"},
+	}
+	for _, c := range cases {
+		r, err := Merge([]byte(c.base), []byte(c.patch), Rison)
+		if err != nil {
+			t.Errorf("merging %s into %s : want no error, got error `%s`", c.patch, c.base, err.Error())
+			continue
+		}
+		if string(r) != c.want {
+			t.Errorf("merging %s into %s : want %s, got %s", c.patch, c.base, c.want, r)
+		}
+	}
+
+	r, err := Merge([]byte("a:1,b:(x:1)"), []byte("b:(y:2)"), ORison)
+	if err != nil || string(r) != "a:1,b:(x:1,y:2)" {
+		t.Errorf("merging in the O-Rison : want a:1,b:(x:1,y:2), got %s, %v", r, err)
+	}
+
+	errs := []struct {
+		base  string
+		patch string
+		want  string
+	}{
+		{"!(1)", "(a:1)", "only objects can be merged, but the base is an array"},
+		{"(a:1)", "!n", "only objects can be merged, but the patch is null"},
+		{"(a:1)", "x", "only objects can be merged, but the patch is a string"},
+	}
+	for _, c := range errs {
+		_, err := Merge([]byte(c.base), []byte(c.patch), Rison)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("merging %s into %s : want the error `%s`, got %v", c.patch, c.base, c.want, err)
+		}
+	}
+	_, err = Merge([]byte("(a:1)"), []byte("(a:"), Rison)
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("merging (a: into (a:1) : want *ParseError, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package rison
+
+import (
+	"encoding/json"
+)
+
+// Kind is the kind of a Rison value.
+type Kind int
+
+const (
+	// KindNull is the kind of !n.
+	KindNull Kind = iota
+	// KindBool is the kind of !t and !f.
+	KindBool
+	// KindNumber is the kind of numbers.
+	KindNumber
+	// KindString is the kind of strings.
+	KindString
+	// KindArray is the kind of arrays.
+	KindArray
+	// KindObject is the kind of objects.
+	KindObject
+)
+
+// Node is a value in a Rison document with its span in the source.
+//
+// A tree of nodes returned by ParseNode can be modified with Set and
+// encoded back with ReassembleNode, which preserves the source as is
+// except for the modified subtrees.
+type Node struct {
+	Kind Kind
+
+	// Value is the value of a scalar: nil, a bool, a float64 or a string.
+	Value interface{}
+
+	// Keys are the object keys corresponding to Children.
+	Keys []string
+
+	// Children are the elements of an array or the values of an object.
+	Children []*Node
+
+	// Start and End are the offsets of the first character of the value
+	// and next to the last character in the source. The descendants of a
+	// node replaced by Set have the offsets in the encoding of the new value.
+	Start int
+	End   int
+
+	// src[from:to] is the encoding of the value.
+	src  []byte
+	from int
+	to   int
+	mode Mode
+}
+
+// ParseNode parses the Rison-encoded data and returns the tree of nodes.
+func ParseNode(data []byte, m Mode) (*Node, error) {
+	b := &nodeBuilder{src: data, mode: m}
+	err := (&parser{Mode: m}).walk(data, b)
+	if err != nil {
+		return nil, err
+	}
+	return b.root, nil
+}
+
+// Get returns the value of the key in the object, or nil if the key is
+// not found. If the key appears more than once, the last one is returned.
+func (n *Node) Get(key string) *Node {
+	for i := len(n.Keys) - 1; 0 <= i; i-- {
+		if n.Keys[i] == key {
+			return n.Children[i]
+		}
+	}
+	return nil
+}
+
+// Set replaces the value of the node with v encoded by Marshal.
+func (n *Node) Set(v interface{}) error {
+	r, err := Marshal(v, n.mode)
+	if err != nil {
+		return err
+	}
+	m, err := ParseNode(r, n.mode)
+	if err != nil {
+		return err
+	}
+	m.Start, m.End = n.Start, n.End
+	*n = *m
+	return nil
+}
+
+// ReassembleNode returns the Rison encoding of the tree of nodes.
+// The source of the unmodified subtrees is copied as is, so the output
+// is byte-identical to the input of ParseNode if nothing is modified.
+func ReassembleNode(n *Node) []byte {
+	return n.appendTo(nil)
+}
+
+// value returns the value of the tree as Decode returns it. The last
+// one of the duplicate keys wins.
+func (n *Node) value() interface{} {
+	switch n.Kind {
+	case KindArray:
+		a := make([]interface{}, len(n.Children))
+		for i, c := range n.Children {
+			a[i] = c.value()
+		}
+		return a
+	case KindObject:
+		o := make(map[string]interface{}, len(n.Children))
+		for i, c := range n.Children {
+			o[n.Keys[i]] = c.value()
+		}
+		return o
+	}
+	return n.Value
+}
+
+func (n *Node) appendTo(buf []byte) []byte {
+	pos := n.from
+	for _, c := range n.Children {
+		buf = append(buf, n.src[pos:c.Start]...)
+		buf = c.appendTo(buf)
+		pos = c.End
+	}
+	return append(buf, n.src[pos:n.to]...)
+}
+
+// nodeBuilder is a handler which builds the tree of nodes.
+type nodeBuilder struct {
+	src   []byte
+	mode  Mode
+	root  *Node
+	stack []*Node
+}
+
+func (b *nodeBuilder) offset(pos int) int {
+	return inputOffset(pos, b.mode, len(b.src))
+}
+
+// inputOffset converts the index in the input wrapped by the mode
+// to the offset in the input of length n. The parentheses wrapping
+// the input are at the ends of the input.
+func inputOffset(pos int, m Mode, n int) int {
+	switch m {
+	case ORison:
+		pos--
+	case ARison:
+		pos -= 2
+	}
+	if pos < 0 {
+		return 0
+	}
+	if n < pos {
+		return n
+	}
+	return pos
+}
+
+func (b *nodeBuilder) add(n *Node, start, end int) {
+	n.Start = b.offset(start)
+	n.End = b.offset(end)
+	n.src = b.src
+	n.from, n.to = n.Start, n.End
+	n.mode = Rison
+	if len(b.stack) == 0 {
+		n.mode = b.mode
+		b.root = n
+		return
+	}
+	top := b.stack[len(b.stack)-1]
+	top.Children = append(top.Children, n)
+}
+
+func (b *nodeBuilder) push(n *Node, start int) {
+	b.add(n, start, start)
+	b.stack = append(b.stack, n)
+}
+
+func (b *nodeBuilder) pop(end int) {
+	n := b.stack[len(b.stack)-1]
+	n.End = b.offset(end)
+	n.to = n.End
+	b.stack = b.stack[:len(b.stack)-1]
+}
+
+func (b *nodeBuilder) beginObject(start int) {
+	b.push(&Node{Kind: KindObject}, start)
+}
+
+func (b *nodeBuilder) endObject(end int) {
+	b.pop(end)
+}
+
+func (b *nodeBuilder) beginArray(start int) {
+	b.push(&Node{Kind: KindArray}, start)
+}
+
+func (b *nodeBuilder) endArray(end int) {
+	b.pop(end)
+}
+
+func (b *nodeBuilder) comma(pos int) {}
+
+func (b *nodeBuilder) colon(pos int) {}
+
+func (b *nodeBuilder) key(s []byte, start, end int) {
+	top := b.stack[len(b.stack)-1]
+	top.Keys = append(top.Keys, string(s))
+}
+
+func (b *nodeBuilder) null(start, end int) {
+	b.add(&Node{Kind: KindNull}, start, end)
+}
+
+func (b *nodeBuilder) boolean(v bool, start, end int) {
+	b.add(&Node{Kind: KindBool, Value: v}, start, end)
+}
+
+func (b *nodeBuilder) number(j []byte, start, end int) {
+	var f float64
+	_ = json.Unmarshal(j, &f) // j is always a valid JSON number
+	b.add(&Node{Kind: KindNumber, Value: f}, start, end)
+}
+
+func (b *nodeBuilder) string(s []byte, start, end int) {
+	b.add(&Node{Kind: KindString, Value: string(s)}, start, end)
+}
@@ -0,0 +1,108 @@
+package rison
+
+import (
+	"testing"
+)
+
+func TestReassembleNode(t *testing.T) {
+	cases := []struct {
+		mode Mode
+		src  string
+	}{
+		{Rison, "(z:'abc',a:!(1.50,'x',!n),m:(k:2e3,'-j':!t))"},
+		{Rison, "'abc'"},
+		{Rison, "!()"},
+		{ORison, "z:'abc',a:!(1,2),m:(k:!f)"},
+		{ORison, ""},
+		{ARison, "'a',!(b,(c:1)),3"},
+		{ARison, ""},
+	}
+	for _, c := range cases {
+		n, err := ParseNode([]byte(c.src), c.mode)
+		if err != nil {
+			t.Fatalf("parsing %s : %s", c.src, err)
+		}
+		got := string(ReassembleNode(n))
+		if got != c.src {
+			t.Errorf("reassembling %s : want the same, got %s", c.src, got)
+		}
+	}
+}
+
+func TestNodeSet(t *testing.T) {
+	cases := []struct {
+		mode Mode
+		src  string
+		path []interface{}
+		v    interface{}
+		want string
+	}{
+		{Rison, "(z:'abc',a:!(1.50,'x',!n),m:(k:2e3,'-j':!t))", []interface{}{"m", "k"}, "new value", "(z:'abc',a:!(1.50,'x',!n),m:(k:'new value','-j':!t))"},
+		{Rison, "(z:'abc',a:!(1.50,'x',!n),m:(k:2e3,'-j':!t))", []interface{}{"a", 1}, map[string]int{"b": 2, "a": 1}, "(z:'abc',a:!(1.50,(a:1,b:2),!n),m:(k:2e3,'-j':!t))"},
+		{Rison, "(z:'abc',a:!(1.50,'x',!n),m:(k:2e3,'-j':!t))", []interface{}{"z"}, nil, "(z:!n,a:!(1.50,'x',!n),m:(k:2e3,'-j':!t))"},
+		{Rison, "'abc'", []interface{}{}, 1, "1"},
+		{ORison, "z:'abc',a:!(1,2)", []interface{}{"a", 0}, true, "z:'abc',a:!(!t,2)"},
+		{ORison, "z:'abc',a:!(1,2)", []interface{}{}, map[string]string{"b": "c"}, "b:c"},
+		{ARison, "'a',!(b,(c:1)),3", []interface{}{1, 1, "c"}, "d", "'a',!(b,(c:d)),3"},
+	}
+	for _, c := range cases {
+		n, err := ParseNode([]byte(c.src), c.mode)
+		if err != nil {
+			t.Fatalf("parsing %s : %s", c.src, err)
+		}
+		target := n
+		for _, k := range c.path {
+			switch k := k.(type) {
+			case string:
+				target = target.Get(k)
+			case int:
+				target = target.Children[k]
+			}
+		}
+		err = target.Set(c.v)
+		if err != nil {
+			t.Fatalf("setting %v in %s : %s", c.v, c.src, err)
+		}
+		got := string(ReassembleNode(n))
+		if got != c.want {
+			t.Errorf("setting %v at %v in %s : want %s, got %s", c.v, c.path, c.src, c.want, got)
+		}
+	}
+}
+
+func TestParseNode(t *testing.T) {
+	n, err := ParseNode([]byte("(a:!(1.50,'x',!n,!t),b:1,b:2)"), Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Kind != KindObject || n.Start != 0 || n.End != 29 {
+		t.Errorf("want an object at [0:29], got %d at [%d:%d]", n.Kind, n.Start, n.End)
+	}
+	a := n.Get("a")
+	want := []struct {
+		kind Kind
+		v    interface{}
+		src  string
+	}{
+		{KindNumber, 1.5, "1.50"},
+		{KindString, "x", "'x'"},
+		{KindNull, nil, "!n"},
+		{KindBool, true, "!t"},
+	}
+	for i, w := range want {
+		c := a.Children[i]
+		src := "(a:!(1.50,'x',!n,!t),b:1,b:2)"[c.Start:c.End]
+		if c.Kind != w.kind || c.Value != w.v || src != w.src {
+			t.Errorf("child %d : want %d %v %s, got %d %v %s", i, w.kind, w.v, w.src, c.Kind, c.Value, src)
+		}
+	}
+	if b := n.Get("b"); b == nil || b.Value != 2.0 {
+		t.Errorf("want the last value of the duplicated key, got %+v", b)
+	}
+	if n.Get("c") != nil {
+		t.Errorf("want nil for a missing key")
+	}
+	if _, err := ParseNode([]byte("(a:"), Rison); err == nil {
+		t.Errorf("want an error for invalid Rison")
+	}
+}
@@ -0,0 +1,167 @@
+package rison
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ParamError is the error of DecodeParam, locating the error in the
+// query parameter value as it was in the URL, before unquoting.
+type ParamError struct {
+	// Name is the name of the parameter.
+	Name string
+	// Pos is the byte offset of the error in the quoted value.
+	Pos int
+	// Err is the underlying error, such as *ParseError or url.EscapeError.
+	Err error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("parameter '%s' invalid at character %d: %s", e.Name, e.Pos, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeParam unquotes the value of the query parameter of the name in
+// the same way as UnquoteString and decodes it as Rison. On an error,
+// it returns *ParamError with the position in the value before
+// unquoting, wrapping *ParseError whose position is in the unquoted
+// Rison.
+func DecodeParam(name, value string, m Mode) (interface{}, error) {
+	r, offsets, perr := unquoteWithOffsets(value)
+	if perr != nil {
+		perr.Name = name
+		return nil, perr
+	}
+	v, err := Decode(r, m)
+	if err != nil {
+		pos := len(value)
+		if pe, ok := err.(*ParseError); ok && pe.Pos < len(offsets) {
+			pos = offsets[pe.Pos]
+		}
+		return nil, &ParamError{Name: name, Pos: pos, Err: err}
+	}
+	return v, nil
+}
+
+// EncodeURLParam returns the query parameter "key=value" where value is
+// the Rison encoding of v quoted by QuoteString, such as q=(a:'x+y').
+// The key is quoted by url.QueryEscape.
+func EncodeURLParam(key string, v interface{}, m Mode) (string, error) {
+	r, err := Marshal(v, m)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryEscape(key) + "=" + QuoteString(string(r)), nil
+}
+
+// DecodeURLParam splits the query parameter "key=value" at the first
+// "=", and returns the unquoted key and the value decoded as Rison by
+// DecodeParam.
+func DecodeURLParam(param string, m Mode) (string, interface{}, error) {
+	k, value, ok := strings.Cut(param, "=")
+	if !ok {
+		return "", nil, fmt.Errorf("missing \"=\" in the parameter %s", param)
+	}
+	key, err := url.QueryUnescape(k)
+	if err != nil {
+		return "", nil, err
+	}
+	v, err := DecodeParam(key, value, m)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, v, nil
+}
+
+// AddToValues sets the Rison encoding of v to the key in values,
+// replacing the existing values of the key. As url.Values holds the
+// values unquoted, use EncodeValues to build a query string with the
+// values quoted by QuoteString, which is shorter than values.Encode().
+func AddToValues(values url.Values, key string, v interface{}, m Mode) error {
+	r, err := Marshal(v, m)
+	if err != nil {
+		return err
+	}
+	values.Set(key, string(r))
+	return nil
+}
+
+// FromValues decodes the first value of the key in values as Rison.
+// It fails if there is no value of the key.
+func FromValues(values url.Values, key string, m Mode) (interface{}, error) {
+	vs, ok := values[key]
+	if !ok || len(vs) == 0 {
+		return nil, fmt.Errorf("no value of the key %s", key)
+	}
+	return Decode([]byte(vs[0]), m)
+}
+
+// EncodeValues is like values.Encode() but quotes the values by
+// QuoteString instead of url.QueryEscape. The keys are sorted and
+// quoted by url.QueryEscape as values.Encode() does.
+func EncodeValues(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range values[k] {
+			if 0 < b.Len() {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(k))
+			b.WriteByte('=')
+			b.WriteString(QuoteString(v))
+		}
+	}
+	return b.String()
+}
+
+// unquoteWithOffsets is like Unquote but also returns the offset in s
+// of each byte of the result, followed by len(s).
+func unquoteWithOffsets(s string) ([]byte, []int, *ParamError) {
+	r := make([]byte, 0, len(s))
+	offsets := make([]int, 0, len(s)+1)
+	for i := 0; i < len(s); i++ {
+		offsets = append(offsets, i)
+		switch c := s[i]; c {
+		case '+':
+			r = append(r, ' ')
+		case '%':
+			if len(s) <= i+2 || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				esc := s[i:]
+				if 3 < len(esc) {
+					esc = esc[:3]
+				}
+				return nil, nil, &ParamError{Pos: i, Err: url.EscapeError(esc)}
+			}
+			r = append(r, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+		default:
+			r = append(r, c)
+		}
+	}
+	return r, append(offsets, len(s)), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	}
+	return c - 'a' + 10
+}
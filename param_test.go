@@ -0,0 +1,148 @@
+package rison
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeParam(t *testing.T) {
+	v, err := DecodeParam("filter", "(type:x,name:'a+b',q:%21(1%2C2))", Rison)
+	want := map[string]interface{}{"type": "x", "name": "a b", "q": []interface{}{float64(1), float64(2)}}
+	if err != nil {
+		t.Errorf("decoding filter : want no error, got error `%s`", err.Error())
+	} else if !reflect.DeepEqual(v, want) {
+		t.Errorf("decoding filter : want %v, got %v", want, v)
+	}
+
+	cases := []struct {
+		value string
+		pos   int
+		typ   ErrType
+	}{
+		{"(type:x,bad:", 12, EEmptyString},
+		{"(type%3Ax%2Cbad:!z)", 17, EInvalidLiteral},
+		{"(a:'x+y',b:%27!q%27)", 16, EInvalidStringEscape},
+	}
+	for _, c := range cases {
+		_, err := DecodeParam("filter", c.value, Rison)
+		var pe *ParamError
+		if !errors.As(err, &pe) {
+			t.Errorf("decoding %s : want *ParamError, got %#v", c.value, err)
+			continue
+		}
+		if pe.Name != "filter" || pe.Pos != c.pos {
+			t.Errorf("decoding %s : want the error of filter at %d, got %s at %d", c.value, c.pos, pe.Name, pe.Pos)
+		}
+		if !errors.Is(err, c.typ) {
+			t.Errorf("decoding %s : want %d, got %v", c.value, c.typ, pe.Err)
+		}
+	}
+
+	_, err = DecodeParam("sort", "(a:%2x)", Rison)
+	var pe *ParamError
+	var ee url.EscapeError
+	if !errors.As(err, &pe) || pe.Name != "sort" || pe.Pos != 3 || !errors.As(err, &ee) {
+		t.Errorf("decoding (a:%%2x) : want url.EscapeError at 3, got %#v", err)
+	}
+	want2 := `parameter 'sort' invalid at character 3: invalid URL escape "%2x"`
+	if err != nil && err.Error() != want2 {
+		t.Errorf("decoding (a:%%2x) : want %s, got %s", want2, err.Error())
+	}
+}
+
+func TestURLParam(t *testing.T) {
+	b := true
+	v := testStruct{I: 1, F: 2.5, S: "x y&z=!'", P: &b, A: []int64{7, 8}, X: map[string]interface{}{"q": "100%"}}
+	param, err := EncodeURLParam("f q", v, Rison)
+	if err != nil {
+		t.Fatalf("encoding %+v : want no error, got error `%s`", v, err.Error())
+	}
+	r, _ := Marshal(v, Rison)
+	q, err := url.ParseQuery(param)
+	if err != nil || len(q) != 1 || q.Get("f q") != string(r) {
+		t.Errorf("parsing %s : want f q=%s, got %v, %v", param, r, q, err)
+	}
+
+	key, decoded, err := DecodeURLParam(param, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", param, err.Error())
+	}
+	want, _ := Decode(r, Rison)
+	if key != "f q" || !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoding %s : want f q and %s, got %s and %s", param, dumpValue(want), key, dumpValue(decoded))
+	}
+	var s testStruct
+	if err := Unmarshal(r, &s, Rison); err != nil || !reflect.DeepEqual(s, v) {
+		t.Errorf("decoding %s : want %+v, got %+v, %v", r, v, s, err)
+	}
+
+	param, err = EncodeURLParam("q", []int{1, 2}, ARison)
+	if err != nil || param != "q=1,2" {
+		t.Errorf("encoding !(1,2) to the A-Rison : want q=1,2, got %s, %v", param, err)
+	}
+
+	for _, p := range []string{"q", "q=(a:", "%zz=1"} {
+		if _, _, err := DecodeURLParam(p, Rison); err == nil {
+			t.Errorf("decoding %s : want an error, got nil", p)
+		}
+	}
+	_, _, err = DecodeURLParam("q=(a:!x)", Rison)
+	var pe *ParamError
+	if !errors.As(err, &pe) || pe.Name != "q" || pe.Pos != 4 {
+		t.Errorf("decoding q=(a:!x) : want *ParamError of q at 4, got %#v", err)
+	}
+	if _, err := EncodeURLParam("q", func() {}, Rison); err == nil {
+		t.Errorf("encoding a func : want an error, got nil")
+	}
+}
+
+func TestValues(t *testing.T) {
+	values := url.Values{}
+	values.Set("page", "2")
+	if err := AddToValues(values, "filter", map[string]interface{}{"type": "a b", "ids": []int{1, 2}}, Rison); err != nil {
+		t.Fatalf("adding filter : want no error, got error `%s`", err.Error())
+	}
+	if err := AddToValues(values, "sort", []string{"-date", "name"}, ARison); err != nil {
+		t.Fatalf("adding sort : want no error, got error `%s`", err.Error())
+	}
+	if err := AddToValues(values, "bad", func() {}, Rison); err == nil {
+		t.Errorf("adding a func : want an error, got nil")
+	}
+
+	qs := EncodeValues(values)
+	want := "filter=(ids:!(1,2),type:'a+b')&page=2&sort='-date',name"
+	if qs != want {
+		t.Errorf("encoding the values : want %s, got %s", want, qs)
+	}
+	if len(qs) >= len(values.Encode()) {
+		t.Errorf("encoding the values : want shorter than %s, got %s", values.Encode(), qs)
+	}
+
+	parsed, err := url.ParseQuery(qs)
+	if err != nil {
+		t.Fatalf("parsing %s : want no error, got error `%s`", qs, err.Error())
+	}
+	filter, err := FromValues(parsed, "filter", Rison)
+	wantFilter := map[string]interface{}{"type": "a b", "ids": []interface{}{float64(1), float64(2)}}
+	if err != nil || !reflect.DeepEqual(filter, wantFilter) {
+		t.Errorf("decoding filter : want %s, got %s, %v", dumpValue(wantFilter), dumpValue(filter), err)
+	}
+	sort, err := FromValues(parsed, "sort", ARison)
+	if err != nil || !reflect.DeepEqual(sort, []interface{}{"-date", "name"}) {
+		t.Errorf("decoding sort : want [-date name], got %s, %v", dumpValue(sort), err)
+	}
+
+	parsed.Add("page", "3")
+	if page, err := FromValues(parsed, "page", Rison); err != nil || page != float64(2) {
+		t.Errorf("decoding page : want the first value 2, got %v, %v", page, err)
+	}
+	if _, err := FromValues(parsed, "missing", Rison); err == nil {
+		t.Errorf("decoding missing : want an error, got nil")
+	}
+	parsed.Set("filter", "(a:1")
+	if _, err := FromValues(parsed, "filter", Rison); !errors.Is(err, EUnmatchedPair) {
+		t.Errorf("decoding (a:1 : want EUnmatchedPair, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package rison
+
+import "bytes"
+
+// Parser decodes many Rison-encoded documents one by one, reusing its
+// buffers across them to reduce the allocations, for high-throughput
+// consumers of small documents.
+//
+// A Parser must not be used by multiple goroutines concurrently.
+type Parser struct {
+	p    parser
+	w    jsonWriter
+	data []byte
+}
+
+// NewParser returns a new Parser decoding in the mode m with the options.
+func NewParser(m Mode, opts DecodeOptions) *Parser {
+	return &Parser{
+		p: parser{Mode: m, DecodeOptions: opts},
+		w: jsonWriter{buffer: &bytes.Buffer{}},
+	}
+}
+
+// Reset sets the document to be decoded next.
+func (ps *Parser) Reset(data []byte) {
+	ps.data = data
+}
+
+// ToJSON is like the function ToJSON for the document set by Reset.
+// The returned slice is valid only until the next call of ToJSON;
+// the buffer is reused, and grown if needed, for the next document.
+func (ps *Parser) ToJSON() ([]byte, error) {
+	ps.w.buffer.Reset()
+	return ps.p.parseTo(ps.data, &ps.w)
+}
+
+// Decode is like the function Decode for the document set by Reset.
+func (ps *Parser) Decode() (interface{}, error) {
+	b := &treeBuilder{useNumber: ps.p.UseNumber, intern: ps.p.InternCommonValues}
+	err := ps.p.walk(ps.data, b)
+	if err != nil {
+		return nil, err
+	}
+	return b.root, nil
+}
@@ -0,0 +1,85 @@
+package rison
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	ps := NewParser(Rison, DecodeOptions{})
+	for r := range testCases {
+		want, _ := ToJSON([]byte(r), Rison)
+		ps.Reset([]byte(r))
+		j, err := ps.ToJSON()
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if string(j) != string(want) {
+			t.Errorf("decoding %s : want %s, got %s", r, want, j)
+		}
+		v, err := ps.Decode()
+		w, _ := Decode([]byte(r), Rison)
+		if err != nil || !reflect.DeepEqual(v, w) {
+			t.Errorf("decoding %s : want %s, got %s, %v", r, dumpValue(w), dumpValue(v), err)
+		}
+	}
+
+	// the parser is usable after an error in a key
+	ps.Reset([]byte("('a"))
+	if _, err := ps.ToJSON(); err == nil {
+		t.Errorf("decoding ('a : want error, got nil")
+	}
+	ps.Reset([]byte("!(a)"))
+	if j, err := ps.ToJSON(); err != nil || string(j) != `["a"]` {
+		t.Errorf(`decoding !(a) : want ["a"], got %s, %v`, j, err)
+	}
+
+	ps = NewParser(ORison, DecodeOptions{SkipWhitespaces: true})
+	ps.Reset([]byte("a : 1"))
+	j, err := ps.ToJSON()
+	if err != nil || string(j) != `{"a":1}` {
+		t.Errorf(`decoding a : 1 : want {"a":1}, got %s, %v`, j, err)
+	}
+	ps.Reset([]byte("(a:1)"))
+	if v, err := ps.Decode(); err == nil {
+		t.Errorf("decoding (a:1) in the O-Rison : want error, got %s", dumpValue(v))
+	}
+}
+
+func benchmarkDocuments() [][]byte {
+	docs := make([][]byte, 100000)
+	for i := range docs {
+		docs[i] = []byte(fmt.Sprintf("(id:%d,name:'item %d',tags:!(a,b),ok:!t)", i, i))
+	}
+	return docs
+}
+
+func BenchmarkToJSONDocuments(b *testing.B) {
+	docs := benchmarkDocuments()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, d := range docs {
+			if _, err := ToJSON(d, Rison); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkParserDocuments(b *testing.B) {
+	docs := benchmarkDocuments()
+	ps := NewParser(Rison, DecodeOptions{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, d := range docs {
+			ps.Reset(d)
+			if _, err := ps.ToJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
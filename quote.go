@@ -25,6 +25,11 @@ var escapeTable = map[string]string{
 }
 
 // QuoteString is like "net/url".QueryEscape but quotes fewer characters.
+//
+// Only ASCII characters are left unquoted; every byte of non-ASCII
+// characters is percent-encoded, so the result is always ASCII-only.
+// Rison has no escape sequence for non-ASCII characters in strings,
+// so this is the layer to use when the output must be ASCII-only.
 func QuoteString(s string) string {
 	return escapeRx.ReplaceAllStringFunc(url.QueryEscape(s), func(m string) string {
 		r, ok := escapeTable[m]
@@ -39,3 +44,18 @@ func QuoteString(s string) string {
 func Quote(s []byte) []byte {
 	return []byte(QuoteString(string(s)))
 }
+
+// UnquoteString is the inverse of QuoteString. It decodes "+" into a space
+// and "%XX" into the byte 0xXX, and fails on a malformed "%" sequence.
+func UnquoteString(s string) (string, error) {
+	return url.QueryUnescape(s)
+}
+
+// Unquote is the inverse of Quote.
+func Unquote(s []byte) ([]byte, error) {
+	u, err := UnquoteString(string(s))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(u), nil
+}
@@ -0,0 +1,208 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+var restMapType = reflect.TypeOf(map[string]interface{}{})
+
+// isRestField reports whether the struct field is tagged with
+// `rison:",rest"` to hold the object members not matched to the other
+// fields. The tag is ignored on the fields of the types other than
+// map[string]interface{}. See Unmarshal for the details.
+func isRestField(sf reflect.StructField) bool {
+	_, opts := parseTag(sf.Tag.Get("rison"))
+	return sf.Type == restMapType && hasOption(opts, "rest")
+}
+
+// restFieldIndex returns the index of the rest field of the struct type t,
+// or -1 if there is none.
+func restFieldIndex(t reflect.Type) int {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath == "" && isRestField(sf) {
+			return i
+		}
+	}
+	return -1
+}
+
+// restMap returns the map in the rest field of the struct v if any.
+func restMap(v reflect.Value) map[string]interface{} {
+	i := restFieldIndex(v.Type())
+	if i < 0 {
+		return nil
+	}
+	return v.Field(i).Interface().(map[string]interface{})
+}
+
+// matchesRestField reports whether "encoding/json" would match the key
+// of an object decoded into a value of type t to the rest field of t,
+// which is not tagged with `json:"-"`, instead of the other fields.
+func matchesRestField(t reflect.Type, key string) bool {
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	i := restFieldIndex(t)
+	if i < 0 {
+		return false
+	}
+	sf := t.Field(i)
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return false
+	}
+	name, _ := parseTag(tag)
+	if name == "" || !isValidTag(name) {
+		name = sf.Name
+	}
+	return strings.EqualFold(key, name) && !hasField(structFields(t), key)
+}
+
+// hasField reports whether any of the fields matches the key
+// in the same way as "encoding/json".
+func hasField(fields []structField, key string) bool {
+	for _, f := range fields {
+		if f.name == key || strings.EqualFold(f.name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsRest reports whether a value of type t can hold a struct
+// with a rest field.
+func needsRest(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	if decodesItself(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return needsRest(t.Elem(), seen)
+	case reflect.Struct:
+		if 0 <= restFieldIndex(t) {
+			return true
+		}
+		for _, f := range structFields(t) {
+			if needsRest(f.typ, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fillRest sets the rest fields of the structs in the value pointed to
+// by v, which the JSON-encoded data j has been decoded into.
+func (p *parser) fillRest(j []byte, v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr || !needsRest(t, map[reflect.Type]bool{}) {
+		return nil
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	if p.UseNumber {
+		d.UseNumber()
+	}
+	var tree interface{}
+	err := d.Decode(&tree)
+	if err != nil {
+		return err
+	}
+	setRest(tree, reflect.ValueOf(v))
+	return nil
+}
+
+// setRest sets the rest fields of the structs in v from the decoded tree.
+func setRest(tree interface{}, v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if decodesItself(v.Type()) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		a, _ := tree.([]interface{})
+		for i := 0; i < len(a) && i < v.Len(); i++ {
+			setRest(a[i], v.Index(i))
+		}
+	case reflect.Map:
+		o, _ := tree.(map[string]interface{})
+		if v.Type().Key().Kind() != reflect.String || !needsRest(v.Type().Elem(), map[reflect.Type]bool{}) {
+			return
+		}
+		for k, m := range o {
+			key := reflect.ValueOf(k).Convert(v.Type().Key())
+			elem := v.MapIndex(key)
+			if !elem.IsValid() {
+				continue
+			}
+			// the elements of a map are not addressable
+			c := reflect.New(elem.Type()).Elem()
+			c.Set(elem)
+			setRest(m, c)
+			v.SetMapIndex(key, c)
+		}
+	case reflect.Struct:
+		o, ok := tree.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := structFields(v.Type())
+		for _, f := range fields {
+			m, ok := memberOf(o, f.name)
+			if !ok {
+				continue
+			}
+			fv, ok := fieldByIndex(v, f.index)
+			if ok {
+				setRest(m, fv)
+			}
+		}
+		i := restFieldIndex(v.Type())
+		if i < 0 {
+			return
+		}
+		var rest map[string]interface{}
+		for k, m := range o {
+			if hasField(fields, k) {
+				continue
+			}
+			if rest == nil {
+				rest = map[string]interface{}{}
+			}
+			rest[k] = m
+		}
+		v.Field(i).Set(reflect.ValueOf(rest))
+	}
+}
+
+// memberOf returns the member of the object matched to the field name
+// in the same way as "encoding/json": the exact key is preferred.
+func memberOf(o map[string]interface{}, name string) (interface{}, bool) {
+	k, ok := memberKey(o, name)
+	return o[k], ok
+}
+
+// memberKey returns the key of the member returned by memberOf.
+func memberKey(o map[string]interface{}, name string) (string, bool) {
+	if _, ok := o[name]; ok {
+		return name, true
+	}
+	for k := range o {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package rison
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testRest struct {
+	Name  string                 `json:"name"`
+	Size  int                    `json:"size"`
+	Extra map[string]interface{} `json:"-" rison:",rest"`
+}
+
+func TestRestField(t *testing.T) {
+	var v testRest
+	r := "(name:x,SIZE:3,color:red,tags:!(a,b),nested:(k:!t))"
+	err := Unmarshal([]byte(r), &v, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	want := testRest{
+		Name: "x",
+		Size: 3,
+		Extra: map[string]interface{}{
+			"color":  "red",
+			"tags":   []interface{}{"a", "b"},
+			"nested": map[string]interface{}{"k": true},
+		},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("decoding %s : want %+v, got %+v", r, want, v)
+	}
+
+	encoded, err := Marshal(v, Rison)
+	wantRison := "(color:red,name:x,nested:(k:!t),size:3,tags:!(a,b))"
+	if err != nil || string(encoded) != wantRison {
+		t.Errorf("encoding %+v : want %s, got %s, %v", v, wantRison, encoded, err)
+	}
+
+	v = testRest{Extra: map[string]interface{}{"old": 1}}
+	err = Unmarshal([]byte("(name:y)"), &v, Rison)
+	if err != nil || v.Name != "y" || v.Extra != nil {
+		t.Errorf("decoding (name:y) : want no rest, got %+v, %v", v, err)
+	}
+
+	v = testRest{Name: "z", Extra: map[string]interface{}{"name": "ignored", "n": nil}}
+	encoded, err = Marshal(v, Rison)
+	wantRison = "(n:!n,name:z,size:0)"
+	if err != nil || string(encoded) != wantRison {
+		t.Errorf("encoding %+v : want %s, got %s, %v", v, wantRison, encoded, err)
+	}
+
+	type outer struct {
+		Items []testRest             `json:"items"`
+		ByID  map[string]testRest    `json:"by_id"`
+		Ptr   *testRest              `json:"ptr"`
+		Any   map[string]interface{} `json:"any"`
+	}
+	var o outer
+	r = "(items:!((name:a,x:1),(y:2)),by_id:(k:(z:3)),ptr:(w:4),any:(q:5))"
+	err = Unmarshal([]byte(r), &o, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if !reflect.DeepEqual(o.Items[0].Extra, map[string]interface{}{"x": float64(1)}) ||
+		!reflect.DeepEqual(o.Items[1].Extra, map[string]interface{}{"y": float64(2)}) ||
+		!reflect.DeepEqual(o.ByID["k"].Extra, map[string]interface{}{"z": float64(3)}) ||
+		!reflect.DeepEqual(o.Ptr.Extra, map[string]interface{}{"w": float64(4)}) ||
+		!reflect.DeepEqual(o.Any, map[string]interface{}{"q": float64(5)}) {
+		t.Errorf("decoding %s : want the rest fields filled, got %+v", r, o)
+	}
+
+	var s struct {
+		A    int               `json:"a"`
+		Rest map[string]string `rison:",rest"`
+	}
+	err = Unmarshal([]byte("(a:1,Rest:(b:c))"), &s, Rison)
+	if err != nil || s.A != 1 || s.Rest["b"] != "c" {
+		t.Errorf("decoding (a:1,Rest:(b:c)) : want the tag ignored on map[string]string, got %+v, %v", s, err)
+	}
+
+	// the rest field without `json:"-"` is not matched to a key
+	var u struct {
+		A     int                    `json:"a"`
+		Extra map[string]interface{} `rison:",rest"`
+	}
+	for _, r := range []string{"(a:1,extra:3)", "(a:1,Extra:3)"} {
+		u.A, u.Extra = 0, nil
+		err = Unmarshal([]byte(r), &u, Rison)
+		if err != nil || u.A != 1 || len(u.Extra) != 1 || u.Extra[r[5:10]] != float64(3) {
+			t.Errorf("decoding %s : want the key in the rest field, got %+v, %v", r, u, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// rewriteJSON rewrites the values in the JSON-encoded data j to be
+// decoded into a value of type t, which "encoding/json" cannot decode
+// as they are:
+//
+//   - the numbers decoded into time.Time values are converted to the
+//     RFC 3339 strings, for TimeUnixSeconds
+//   - the values decoded by UnmarshalRison are replaced with null, to
+//     be set by setRison after decoding
+//   - the keys "encoding/json" would match to the rest fields are
+//     replaced with "", to be set in the rest fields by fillRest
+//
+// The positions of the values are shifted accordingly.
+func (p *parser) rewriteJSON(j []byte, t reflect.Type) ([]byte, error) {
+	times := p.TimeFormat == TimeUnixSeconds && needsTimeConversion(t, map[reflect.Type]bool{})
+	if !times && !needsRisonUnmarshaler(t, map[reflect.Type]bool{}) && !needsRest(t, map[reflect.Type]bool{}) {
+		return j, nil
+	}
+	r := &jsonRewriter{j: j, dec: json.NewDecoder(bytes.NewReader(j)), times: times, p: p}
+	r.dec.UseNumber()
+	err := r.rewrite(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.edits) == 0 {
+		return j, nil
+	}
+	out := make([]byte, 0, len(j)+len(r.edits)*16)
+	last := 0
+	delta := 0
+	k := 0
+	for _, e := range r.edits {
+		for ; k < len(p.positions) && p.positions[k].json < e.end; k++ {
+			p.positions[k].json += delta
+		}
+		out = append(out, j[last:e.start]...)
+		out = append(out, e.text...)
+		delta += len(e.text) - (e.end - e.start)
+		last = e.end
+	}
+	for ; k < len(p.positions); k++ {
+		p.positions[k].json += delta
+	}
+	return append(out, j[last:]...), nil
+}
+
+// jsonRewriter walks the JSON with the types to find the values
+// to be rewritten.
+type jsonRewriter struct {
+	j     []byte
+	dec   *json.Decoder
+	times bool
+	p     *parser
+	edits []jsonEdit
+}
+
+// rawNumber returns the number starting at the offset in the JSON as
+// written in the Rison input, not to lose the fractions of seconds
+// beyond the precision of float64.
+func (r *jsonRewriter) rawNumber(offset int, j string) string {
+	if len(r.p.positions) == 0 {
+		return j
+	}
+	s := r.p.string
+	start := r.p.risonIndex(offset + 1)
+	end := start
+	for end < len(s) && 0 <= strings.IndexByte("+-.0123456789eE", s[end]) {
+		end++
+	}
+	if start == end {
+		return j
+	}
+	return string(s[start:end])
+}
+
+// jsonEdit replaces the JSON-encoded data between start and end with text.
+type jsonEdit struct {
+	start, end int
+	text       []byte
+}
+
+func (r *jsonRewriter) rewrite(t reflect.Type) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && unmarshalsRison(t) {
+		var raw json.RawMessage
+		err := r.dec.Decode(&raw)
+		if err != nil {
+			return err
+		}
+		end := int(r.dec.InputOffset())
+		r.edits = append(r.edits, jsonEdit{start: end - len(raw), end: end, text: []byte("null")})
+		return nil
+	}
+	if t != nil && t != timeType && decodesItself(t) {
+		t = nil
+	}
+	tok, err := r.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Number:
+		if !r.times || t != timeType {
+			return nil
+		}
+		end := int(r.dec.InputOffset())
+		tm, ok := parseUnixSeconds(r.rawNumber(end-len(tok), string(tok)))
+		if !ok {
+			return nil // left to be reported by "encoding/json"
+		}
+		text, _ := json.Marshal(tm.Format(time.RFC3339Nano))
+		r.edits = append(r.edits, jsonEdit{start: end - len(tok), end: end, text: text})
+	case json.Delim:
+		switch tok {
+		case '[':
+			var elem reflect.Type
+			if t != nil && (t.Kind() == reflect.Array || t.Kind() == reflect.Slice) {
+				elem = t.Elem()
+			}
+			for r.dec.More() {
+				err = r.rewrite(elem)
+				if err != nil {
+					return err
+				}
+			}
+		case '{':
+			for r.dec.More() {
+				start := int(r.dec.InputOffset())
+				k, err := r.dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := k.(string)
+				if matchesRestField(t, key) {
+					for r.j[start] != '"' {
+						start++ // skip ","
+					}
+					end := int(r.dec.InputOffset())
+					r.edits = append(r.edits, jsonEdit{start: start, end: end, text: []byte(`""`)})
+				}
+				err = r.rewrite(memberType(t, key))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		_, err = r.dec.Token()
+		return err
+	}
+	return nil
+}
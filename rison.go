@@ -14,6 +14,7 @@ const (
 	notIDChar        = ` '!:(),*@$`
 	notIDStart       = notIDChar + `0123456789-`
 	parserWhitespace = " \t\n\r\f"
+	keyOperatorChars = `*@$`
 )
 
 // Mode is an enum type to specify which Rison variation to use to encode/decode.
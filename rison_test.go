@@ -2,12 +2,20 @@ package rison
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/netip"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var testCases = map[string]string{
@@ -151,8 +159,18 @@ var invalidDecodeCases = []interface{}{
 	[]byte{0xff, 0xfe, 0xfd},
 }
 
+type testStruct struct {
+	I int64       `json:"i"`
+	F float64     `json:"f"`
+	S string      `json:"s"`
+	B bool        `json:"b"`
+	P *bool       `json:"p"`
+	A []int64     `json:"a"`
+	X interface{} `json:"x"`
+}
+
 var invalidEncodeCases = []interface{}{
-	map[float64]int{1.0: 1},
+	map[bool]int{true: 1},
 	complex(.0, 1.0),
 	make(chan struct{}),
 	func() {},
@@ -256,6 +274,91 @@ func TestDecodeDeepNestedObject(t *testing.T) {
 	}
 }
 
+func TestDecodeMaxDepth(t *testing.T) {
+	nested := func(n int, open, close string) string {
+		return strings.Repeat(open, n) + "1" + strings.Repeat(close, n)
+	}
+	opts := DecodeOptions{MaxDepth: 100}
+	for _, r := range []string{nested(100, "(a:", ")"), nested(100, "!(", ")"), nested(50, "(a:!(", "))"), "1", "!()"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %.20s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+	if _, err := DecodeWithOptions([]byte(nested(99, "!(", ")")), ARison, opts); err != nil {
+		t.Errorf("decoding the A-Rison of depth 100 : want no error, got error `%s`", err.Error())
+	}
+
+	cases := []struct {
+		r   string
+		m   Mode
+		pos int
+	}{
+		{nested(101, "(a:", ")"), Rison, 300},
+		{nested(101, "!(", ")"), Rison, 200},
+		{nested(51, "(a:!(", "))"), Rison, 250},
+		{nested(100000, "!(", ")"), Rison, 200},
+		{nested(100, "!(", ")"), ARison, 198},
+		{"a:" + nested(100, "(a:", ")"), ORison, 299},
+	}
+	for _, c := range cases {
+		v, err := DecodeWithOptions([]byte(c.r), c.m, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EMaxDepthExceeded {
+			t.Errorf("decoding %.20s : want EMaxDepthExceeded, got %s, %v", c.r, dumpValue(v), err)
+			continue
+		}
+		if e.Pos != c.pos {
+			t.Errorf("decoding %.20s : want the error at %d, got %d", c.r, c.pos, e.Pos)
+		}
+	}
+
+	var v interface{}
+	err := UnmarshalWithOptions([]byte(nested(3, "!(", ")")), &v, Rison, DecodeOptions{MaxDepth: 2})
+	want := "arrays and objects nested deeper than 2"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("decoding !(!(!(1))) : want the error `%s`, got %v", want, err)
+	}
+}
+
+// countdownContext is a context which is cancelled after Err is called
+// the number of times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestDecodeContext(t *testing.T) {
+	r := strings.Repeat("(a:!(1,2,x),b:", 10000) + "!n" + strings.Repeat(")", 10000)
+	v, err := DecodeContext(context.Background(), []byte(r), Rison)
+	if err != nil || v == nil {
+		t.Errorf("decoding %.20s : want no error, got %v", r, err)
+	}
+
+	ctx := &countdownContext{Context: context.Background(), n: 10}
+	v, err = DecodeContext(ctx, []byte(r), Rison)
+	if err != context.Canceled {
+		t.Errorf("decoding %.20s : want context.Canceled, got %s, %v", r, dumpValue(v), err)
+	}
+	if ctx.n != 0 {
+		t.Errorf("decoding %.20s : want parsing stopped at the check, got %d checks left", r, ctx.n)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	v, err = DecodeContext(cancelled, []byte("(a:1)"), Rison)
+	if err != context.Canceled {
+		t.Errorf("decoding (a:1) : want context.Canceled, got %s, %v", dumpValue(v), err)
+	}
+}
+
 func TestDecodeDeepNestedArray(t *testing.T) {
 	l := ""
 	r := ""
@@ -380,8 +483,8 @@ func TestInvalidEncodeValue(t *testing.T) {
 		[]interface{}{
 			func() {},
 		},
-		map[float64]interface{}{
-			.1: "",
+		map[bool]interface{}{
+			true: "",
 		},
 	}
 
@@ -391,9 +494,2700 @@ func TestInvalidEncodeValue(t *testing.T) {
 	}
 	for _, v := range cases {
 		vv := reflect.ValueOf(v)
-		err := e.encodeValue("", vv)
+		err := e.encodeValue(vv)
 		if err == nil {
 			t.Errorf("encodeValue %#v : want *ParseError, got nil", v)
 		}
 	}
 }
+
+func TestNestedRison(t *testing.T) {
+	inner := map[string]interface{}{"type": "x", "ids": []interface{}{1.0, 2.0}}
+	v := map[string]interface{}{"filter": NestedRison(inner, Rison)}
+	r, err := Marshal(v, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(filter:'(ids:!!(1,2),type:x)')"
+	if string(r) != want {
+		t.Errorf("encoding %#v : want %s, got %s", v, want, string(r))
+	}
+
+	decoded, err := Decode(r, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := decoded.(map[string]interface{})["filter"].(string)
+	if !ok {
+		t.Fatalf("decoding %s : want a string value, got %s", string(r), dumpValue(decoded))
+	}
+	redecoded, err := Decode([]byte(s), Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(inner, redecoded) {
+		t.Errorf("decoding %s : want %s, got %s", s, dumpValue(inner), dumpValue(redecoded))
+	}
+}
+
+func TestValidateReader(t *testing.T) {
+	elem := "(id:12345,name:'foo bar',tags:!(a,b,c),ok:!t),"
+	n := (4 << 20) / len(elem)
+	r := "!(" + strings.Repeat(elem, n) + "!n)"
+	err := ValidateReader(strings.NewReader(r), Rison)
+	if err != nil {
+		t.Errorf("validating %s .. : want no error, got error `%s`", r[:100], err.Error())
+	}
+
+	r = "!(" + strings.Repeat(elem, n) + "!x)"
+	err = ValidateReader(strings.NewReader(r), Rison)
+	e, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("validating %s .. : want *ParseError, got %#v", r[:100], err)
+	}
+	if e.Type != EInvalidLiteral || e.Pos != len(r)-2 {
+		t.Errorf("validating %s .. : want EInvalidLiteral at %d, got %d at %d", r[:100], len(r)-2, e.Type, e.Pos)
+	}
+
+	for rs := range testCases {
+		for _, m := range testModes([]byte(rs)) {
+			r := mustConvertMode([]byte(rs), m)
+			if err := ValidateReader(bytes.NewReader(r), m); err != nil {
+				t.Errorf("validating %s : want no error, got error `%s`", string(r), err.Error())
+			}
+		}
+	}
+}
+
+func TestDecodeDelimited(t *testing.T) {
+	cases := []struct {
+		data  string
+		delim byte
+		want  []interface{}
+		fails []bool
+	}{
+		{
+			data:  "(a:1)\x1e!(x,y)\x1e'rec\x1esep'\x1e",
+			delim: '\x1e',
+			want: []interface{}{
+				map[string]interface{}{"a": 1.0},
+				[]interface{}{"x", "y"},
+				"rec\x1esep",
+			},
+			fails: []bool{false, false, false},
+		},
+		{
+			data:  "(a:'x;y');'it!'s;';(b:",
+			delim: ';',
+			want: []interface{}{
+				map[string]interface{}{"a": "x;y"},
+				"it's;",
+				nil,
+			},
+			fails: []bool{false, false, true},
+		},
+	}
+	for _, c := range cases {
+		values, errs := DecodeDelimited([]byte(c.data), c.delim, Rison)
+		if len(values) != len(c.want) || len(errs) != len(c.want) {
+			t.Errorf("decoding %q : want %d documents, got %d values and %d errors", c.data, len(c.want), len(values), len(errs))
+			continue
+		}
+		for i := range c.want {
+			if c.fails[i] {
+				if _, ok := errs[i].(*ParseError); !ok {
+					t.Errorf("decoding %q : want *ParseError at [%d], got %#v", c.data, i, errs[i])
+				}
+				continue
+			}
+			if errs[i] != nil {
+				t.Errorf("decoding %q : want no error at [%d], got error `%s`", c.data, i, errs[i].Error())
+			} else if !reflect.DeepEqual(c.want[i], values[i]) {
+				t.Errorf("decoding %q : want %s at [%d], got %s", c.data, dumpValue(c.want[i]), i, dumpValue(values[i]))
+			}
+		}
+	}
+
+	for _, delim := range []byte{'\'', '!'} {
+		values, errs := DecodeDelimited([]byte("a"+string(delim)+"b"), delim, Rison)
+		if len(values) != 1 || len(errs) != 1 || errs[0] == nil {
+			t.Errorf("decoding with the delimiter %q : want an error, got %v, %v", delim, values, errs)
+		}
+	}
+}
+
+func TestMarshalDecodedTree(t *testing.T) {
+	r := "(z:(y:!((q:1,p:2),!((b:!t,a:!f))),x:'1'),m:1.50,a:'b')"
+	decoded, err := Decode([]byte(r), Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := decoded.(map[string]interface{})
+	tree["c"] = map[string]interface{}{"k": []interface{}{"v", map[string]interface{}{"n": nil, "e": ""}}}
+	z := tree["z"].(map[string]interface{})
+	z["w"] = "new value"
+	z["y"].([]interface{})[0].(map[string]interface{})["o"] = 3.0
+
+	encoded, err := Marshal(tree, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(a:b,c:(k:!(v,(e:'',n:!n))),m:1.5,z:(w:'new value',x:'1',y:!((o:3,p:2,q:1),!((a:!f,b:!t)))))"
+	if string(encoded) != want {
+		t.Errorf("encoding %s : want %s, got %s", dumpValue(tree), want, string(encoded))
+	}
+
+	redecoded, err := Decode(encoded, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tree, redecoded) {
+		t.Errorf("decoding %s : want %s, got %s", string(encoded), dumpValue(tree), dumpValue(redecoded))
+	}
+	reencoded, err := Marshal(redecoded, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, reencoded) {
+		t.Errorf("encoding %s : want %s, got %s", dumpValue(redecoded), string(encoded), string(reencoded))
+	}
+}
+
+func TestQuoteStringNonASCII(t *testing.T) {
+	r := "(花:上野,🍣:'🐟 x')"
+	qs := QuoteString(r)
+	want := "(%E8%8A%B1:%E4%B8%8A%E9%87%8E,%F0%9F%8D%A3:'%F0%9F%90%9F+x')"
+	if qs != want {
+		t.Errorf("escaping %s : want %s, got %s", r, want, qs)
+	}
+	for i := 0; i < len(qs); i++ {
+		if 0x80 <= qs[i] {
+			t.Errorf("escaping %s : want ASCII-only, got %s", r, qs)
+			break
+		}
+	}
+	u, err := url.QueryUnescape(qs)
+	if err != nil {
+		t.Fatalf("unescaping %s : want %s, got error `%s`", qs, r, err.Error())
+	}
+	if u != r {
+		t.Errorf("unescaping %s : want %s, got %s", qs, r, u)
+	}
+}
+
+func TestDecodeTo(t *testing.T) {
+	a, err := DecodeTo[[]int64]([]byte("1,2,3"), ARison)
+	if err != nil {
+		t.Errorf("decoding 1,2,3 : want no error, got error `%s`", err.Error())
+	} else if !reflect.DeepEqual(a, []int64{1, 2, 3}) {
+		t.Errorf("decoding 1,2,3 : want [1 2 3], got %v", a)
+	}
+
+	s, err := DecodeTo[testStruct]([]byte("i:1,a:!(7,8,9),x:(y:Y)"), ORison)
+	if err != nil {
+		t.Errorf("decoding i:1,a:!(7,8,9),x:(y:Y) : want no error, got error `%s`", err.Error())
+	} else if s.I != 1 || !reflect.DeepEqual(s.A, []int64{7, 8, 9}) || !reflect.DeepEqual(s.X, map[string]interface{}{"y": "Y"}) {
+		t.Errorf("decoding i:1,a:!(7,8,9),x:(y:Y) : got %+v", s)
+	}
+
+	_, err = DecodeTo[[]int64]([]byte("1,2.5,3"), ARison)
+	e, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("decoding 1,2.5,3 : want *ParseError, got %#v", err)
+	}
+	if e.Type != ETypeMismatch || e.Pos != 2 {
+		t.Errorf("decoding 1,2.5,3 : want ETypeMismatch at 2, got %d at %d", e.Type, e.Pos)
+	}
+	want := `cannot unmarshal number 2.5 into ".1" of type int64 (at [2] near "1," -> "2" -> ".5,3")`
+	if e.Error() != want {
+		t.Errorf("decoding 1,2.5,3 : want %s, got %s", want, e.Error())
+	}
+	var te *json.UnmarshalTypeError
+	if !errors.As(err, &te) {
+		t.Errorf("decoding 1,2.5,3 : want to wrap *json.UnmarshalTypeError, got %#v", e.Child)
+	}
+
+	_, err = DecodeTo[map[string]int64]([]byte("1,2,3"), ARison)
+	if err == nil {
+		t.Errorf("decoding 1,2,3 into map[string]int64 : want an error, got nil")
+	}
+	_, err = DecodeTo[[]int64]([]byte("a:1"), ORison)
+	if err == nil {
+		t.Errorf("decoding a:1 into []int64 : want an error, got nil")
+	}
+}
+
+func TestDecodeTyped(t *testing.T) {
+	s, err := DecodeTyped[testStruct]([]byte("(i:1,s:x,a:!(7,8))"), Rison)
+	if err != nil {
+		t.Errorf("decoding (i:1,s:x,a:!(7,8)) : want no error, got error `%s`", err.Error())
+	} else if s.I != 1 || s.S != "x" || !reflect.DeepEqual(s.A, []int64{7, 8}) {
+		t.Errorf("decoding (i:1,s:x,a:!(7,8)) : got %+v", s)
+	}
+
+	m, err := DecodeTyped[map[string]interface{}]([]byte("a:1,b:!(x,!t)"), ORison)
+	want := map[string]interface{}{"a": float64(1), "b": []interface{}{"x", true}}
+	if err != nil {
+		t.Errorf("decoding a:1,b:!(x,!t) : want no error, got error `%s`", err.Error())
+	} else if !reflect.DeepEqual(m, want) {
+		t.Errorf("decoding a:1,b:!(x,!t) : want %v, got %v", want, m)
+	}
+
+	p, err := DecodeTyped[*testStruct]([]byte("(i:2)"), Rison)
+	if err != nil || p == nil || p.I != 2 {
+		t.Errorf("decoding (i:2) into *testStruct : want &{I:2}, got %+v, %v", p, err)
+	}
+	p, err = DecodeTyped[*testStruct]([]byte("!n"), Rison)
+	if err != nil || p != nil {
+		t.Errorf("decoding !n into *testStruct : want nil, got %+v, %v", p, err)
+	}
+
+	_, err = DecodeTyped[testStruct]([]byte("(i:'x')"), Rison)
+	e, ok := err.(*ParseError)
+	if !ok || e.Type != ETypeMismatch || e.Pos != 3 {
+		t.Errorf("decoding (i:'x') : want ETypeMismatch at 3, got %#v", err)
+	}
+}
+
+func TestEncodeTyped(t *testing.T) {
+	b := true
+	s := testStruct{I: 1, F: 2.5, S: "x y", B: true, P: &b, A: []int64{7, 8}, X: map[string]interface{}{"y": "Y"}}
+	check := func(got []byte, err error, v interface{}) {
+		t.Helper()
+		want, werr := Marshal(v, Rison)
+		if (err == nil) != (werr == nil) || string(got) != string(want) {
+			t.Errorf("encoding %+v : want %s, %v, got %s, %v", v, want, werr, got, err)
+		}
+	}
+	got, err := EncodeTyped(s, Rison)
+	check(got, err, s)
+	got, err = EncodeTyped(&s, Rison)
+	check(got, err, &s)
+	got, err = EncodeTyped([]testStruct{s, {}}, Rison)
+	check(got, err, []testStruct{s, {}})
+	got, err = EncodeTyped(map[string]int{"b": 2, "a": 1}, Rison)
+	check(got, err, map[string]int{"b": 2, "a": 1})
+	got, err = EncodeTyped[*testStruct](nil, Rison)
+	check(got, err, (*testStruct)(nil))
+	got, err = EncodeTyped(map[string]interface{}{"f": json.Number("x")}, Rison)
+	check(got, err, map[string]interface{}{"f": json.Number("x")})
+	if err == nil {
+		t.Errorf("encoding an invalid json.Number : want an error, got nil")
+	}
+}
+
+func TestUnmarshalTypeMismatch(t *testing.T) {
+	r := "(i:1,a:!(7,x,9))"
+	var v testStruct
+	err := Unmarshal([]byte(r), &v, Rison)
+	e, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("decoding %s : want *ParseError, got %#v", r, err)
+	}
+	if e.Type != ETypeMismatch || e.Pos != 11 {
+		t.Errorf("decoding %s : want ETypeMismatch at 11, got %d at %d", r, e.Type, e.Pos)
+	}
+	if !strings.Contains(e.Error(), `".a.1"`) {
+		t.Errorf("decoding %s : want the path .a.1 in the message, got %s", r, e.Error())
+	}
+}
+
+func TestLenientStringEscapes(t *testing.T) {
+	r := "(a:'a!n',b:'x!!!'!t')"
+	_, err := Decode([]byte(r), Rison)
+	e, ok := err.(*ParseError)
+	if !ok || e.Type != EInvalidStringEscape {
+		t.Errorf("decoding %s : want EInvalidStringEscape, got %#v", r, err)
+	}
+
+	opts := DecodeOptions{LenientStringEscapes: true}
+	decoded, err := DecodeWithOptions([]byte(r), Rison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	want := map[string]interface{}{"a": "a!n", "b": "x!'!t"}
+	if !reflect.DeepEqual(want, decoded) {
+		t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(decoded))
+	}
+
+	for _, r := range []string{"'a!", "'a!n"} {
+		_, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("decoding %s : want *ParseError, got %#v", r, err)
+		}
+	}
+}
+
+func TestEncodeJSONNumber(t *testing.T) {
+	r := "(big:9007199254740993,f:-1.5e-7,i:1,s:'1')"
+	j := `{"big":9007199254740993,"f":-1.5e-7,"i":1,"s":"1"}`
+	dec := json.NewDecoder(strings.NewReader(j))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &encoder{
+		buffer: bytes.NewBuffer([]byte{}),
+		Mode:   Rison,
+	}
+	if err := e.encodeValue(reflect.ValueOf(tree)); err != nil {
+		t.Fatalf("encodeValue %s : want no error, got error `%s`", dumpValue(tree), err.Error())
+	}
+	if e.buffer.String() != r {
+		t.Errorf("encodeValue %s : want %s, got %s", dumpValue(tree), r, e.buffer.String())
+	}
+
+	encoded, err := Marshal(tree, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(encoded, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := decoded.(map[string]interface{})
+	for _, k := range []string{"big", "f", "i"} {
+		if _, ok := m[k].(float64); !ok {
+			t.Errorf("encoding %s : want a number at .%s, got %s", dumpValue(tree), k, string(encoded))
+		}
+	}
+	if _, ok := m["s"].(string); !ok {
+		t.Errorf("encoding %s : want a string at .s, got %s", dumpValue(tree), string(encoded))
+	}
+
+	for _, n := range []json.Number{"1E+5", "2e+10"} {
+		e.buffer.Reset()
+		if err := e.encodeValue(reflect.ValueOf(n)); err != nil {
+			t.Fatalf("encodeValue %s : want no error, got error `%s`", n, err.Error())
+		}
+		if _, err := Decode(e.buffer.Bytes(), Rison); err != nil {
+			t.Errorf("encodeValue %s : want valid Rison, got %s", n, e.buffer.String())
+		}
+	}
+
+	e.buffer.Reset()
+	if err := e.encodeValue(reflect.ValueOf(json.Number("x"))); err == nil {
+		t.Errorf("encodeValue %s : want an error, got %s", "x", e.buffer.String())
+	}
+}
+
+func TestOnPrecisionLoss(t *testing.T) {
+	cases := map[string][]string{
+		"(id:9007199254740993)": {"9007199254740993"},
+		"(id:42)":               nil,
+		"(id:-0,f:0.1,e:1e30)":  nil,
+		"!(1180591620717411303424,-18014398509481985)": {"-18014398509481985"},
+	}
+	for r, want := range cases {
+		var got []string
+		opts := DecodeOptions{
+			OnPrecisionLoss: func(raw []byte) {
+				got = append(got, string(raw))
+			},
+		}
+		_, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil {
+			t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("decoding %s : want OnPrecisionLoss called with %v, got %v", r, want, got)
+		}
+	}
+}
+
+func TestTrimOuterShellQuotes(t *testing.T) {
+	cases := []struct {
+		r    string
+		mode Mode
+		want interface{}
+	}{
+		{"'(a:1)'", Rison, map[string]interface{}{"a": 1.0}},
+		{"'!(a,b)'", Rison, []interface{}{"a", "b"}},
+		{"'abc'", Rison, "abc"},
+		{"'1'", Rison, "1"},
+		{"'(a:1'", Rison, "(a:1"},
+		{"'a:1,b:!(x)'", ORison, map[string]interface{}{"a": 1.0, "b": []interface{}{"x"}}},
+		{"'1,2'", ARison, []interface{}{1.0, 2.0}},
+	}
+	opts := DecodeOptions{TrimOuterShellQuotes: true}
+	for _, c := range cases {
+		v, err := DecodeWithOptions([]byte(c.r), c.mode, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", c.r, err.Error())
+		} else if !reflect.DeepEqual(c.want, v) {
+			t.Errorf("decoding %s : want %s, got %s", c.r, dumpValue(c.want), dumpValue(v))
+		}
+	}
+
+	v, err := Decode([]byte("'(a:1)'"), Rison)
+	if err != nil || v != "(a:1)" {
+		t.Errorf("decoding '(a:1)' : want \"(a:1)\", got %s and %v", dumpValue(v), err)
+	}
+}
+
+func TestByteSlice(t *testing.T) {
+	type binary struct {
+		Data []byte `json:"data"`
+		Nil  []byte `json:"nil"`
+	}
+	v := binary{Data: []byte("hi")}
+	r, err := Marshal(v, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(data:aGk=,nil:!n)"
+	if string(r) != want {
+		t.Errorf("encoding %+v : want %s, got %s", v, want, string(r))
+	}
+	var decoded binary
+	if err := Unmarshal(r, &decoded, Rison); err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", string(r), err.Error())
+	}
+	if !reflect.DeepEqual(v, decoded) {
+		t.Errorf("decoding %s : want %+v, got %+v", string(r), v, decoded)
+	}
+
+	r = []byte("(data:'not base64!!')")
+	err = Unmarshal(r, &decoded, Rison)
+	if err == nil {
+		t.Fatalf("decoding %s : want an error, got nil", string(r))
+	}
+	e, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("decoding %s : want *ParseError, got %#v", string(r), err)
+	}
+	if e.Type != ETypeMismatch || e.Pos != 6 || !strings.Contains(e.Error(), `".data"`) {
+		t.Errorf("decoding %s : want ETypeMismatch citing .data at 6, got %s", string(r), e.Error())
+	}
+}
+
+func TestLiteralAliases(t *testing.T) {
+	opts := DecodeOptions{
+		LiteralAliases: map[string]interface{}{
+			"yes": true,
+			"no":  false,
+			"nil": nil,
+			"pi":  3.14,
+		},
+	}
+	r := "(active:yes,deleted:no,v:nil,yes:'yes',list:!(pi,no,maybe))"
+	v, err := DecodeWithOptions([]byte(r), Rison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	want := map[string]interface{}{
+		"active":  true,
+		"deleted": false,
+		"v":       nil,
+		"yes":     "yes",
+		"list":    []interface{}{3.14, false, "maybe"},
+	}
+	if !reflect.DeepEqual(want, v) {
+		t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+	}
+
+	v, err = Decode([]byte(r), Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(map[string]interface{})["active"] != "yes" {
+		t.Errorf("decoding %s : want a string yes without aliases, got %s", r, dumpValue(v))
+	}
+
+	opts.LiteralAliases["bad"] = make(chan int)
+	_, err = DecodeWithOptions([]byte("bad"), Rison, opts)
+	if e, ok := err.(*ParseError); !ok || e.Type != EInternal {
+		t.Errorf("decoding bad : want EInternal, got %#v", err)
+	}
+}
+
+func TestMarshalMixedDocument(t *testing.T) {
+	yes := true
+	v := map[string]interface{}{
+		"a": testStruct{I: 1, F: 2.3, S: "str", B: true, A: []int64{7, 8, 9}, X: map[string]interface{}{"y": "Y"}},
+		"b": []int{1, 2},
+		"c": map[string]int{"x": 1},
+		"d": &testStruct{P: &yes, X: []interface{}{map[string]string{"k": "v w"}}},
+		"e": struct {
+			Z int    `json:"z"`
+			A string `json:"a,omitempty"`
+			M map[string]interface{}
+		}{Z: 3, M: map[string]interface{}{"n": nil}},
+		"f": []interface{}{testStruct{}, map[string]interface{}{}, "s"},
+	}
+	r, err := Marshal(v, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(a:(a:!(7,8,9),b:!t,f:2.3,i:1,p:!n,s:str,x:(y:Y))," +
+		"b:!(1,2)," +
+		"c:(x:1)," +
+		"d:(a:!n,b:!f,f:0,i:0,p:!t,s:'',x:!((k:'v w')))," +
+		"e:(M:(n:!n),z:3)," +
+		"f:!((a:!n,b:!f,f:0,i:0,p:!n,s:'',x:!n),(),s))"
+	if string(r) != want {
+		t.Errorf("encoding %#v : want %s, got %s", v, want, string(r))
+	}
+
+	decoded, err := Decode(r, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", string(r), err.Error())
+	}
+	reencoded, err := Marshal(decoded, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, reencoded) {
+		t.Errorf("encoding %s : want %s, got %s", dumpValue(decoded), string(r), string(reencoded))
+	}
+}
+
+func TestDisallowEmptyKeys(t *testing.T) {
+	opts := DecodeOptions{DisallowEmptyKeys: true}
+	cases := map[string]int{
+		"('':1)":           1,
+		"(a:(b:1,'':2))":   8,
+		"!(x,(a:1,'':!n))": 9,
+	}
+	for r, pos := range cases {
+		v, err := Decode([]byte(r), Rison)
+		if err != nil {
+			t.Errorf("decoding %s : want no error without the option, got error `%s`", r, err.Error())
+		}
+		_, err = DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %s", r, dumpValue(v))
+			continue
+		}
+		if e.Type != EEmptyKey || e.Pos != pos {
+			t.Errorf("decoding %s : want EEmptyKey at %d, got %d at %d", r, pos, e.Type, e.Pos)
+		}
+	}
+
+	for _, r := range []string{"(a:'',b:!(''))", "('a':1,'!'':2)"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+}
+
+func TestConvertMode(t *testing.T) {
+	cases := []struct {
+		from Mode
+		to   Mode
+		src  string
+		want string
+	}{
+		{Rison, ORison, "(a:1,b:!(x,'y z'))", "a:1,b:!(x,'y z')"},
+		{ORison, Rison, "a:1,b:!(x,'y z')", "(a:1,b:!(x,'y z'))"},
+		{Rison, ORison, "()", ""},
+		{ORison, Rison, "", "()"},
+		{Rison, ARison, "!(1,(a:b),!n)", "1,(a:b),!n"},
+		{ARison, Rison, "1,(a:b),!n", "!(1,(a:b),!n)"},
+		{Rison, ARison, "!()", ""},
+		{ARison, Rison, "", "!()"},
+		{Rison, Rison, "'a b'", "'a b'"},
+		{ORison, ORison, "a:1", "a:1"},
+	}
+	for _, c := range cases {
+		r, err := ConvertMode([]byte(c.src), c.from, c.to)
+		if err != nil {
+			t.Errorf("converting %s : want no error, got error `%s`", c.src, err.Error())
+			continue
+		}
+		if string(r) != c.want {
+			t.Errorf("converting %s : want %s, got %s", c.src, c.want, string(r))
+		}
+	}
+
+	errorCases := []struct {
+		from Mode
+		to   Mode
+		src  string
+	}{
+		{Rison, ORison, "!(1,2)"},
+		{Rison, ORison, "abc"},
+		{Rison, ARison, "(a:1)"},
+		{Rison, ARison, "!n"},
+		{ORison, ARison, "a:1"},
+		{ARison, ORison, "1,2"},
+		{Rison, ORison, "(a:1"},
+		{ORison, Rison, "a:1)"},
+	}
+	for _, c := range errorCases {
+		r, err := ConvertMode([]byte(c.src), c.from, c.to)
+		if err == nil {
+			t.Errorf("converting %s : want error, got %s", c.src, string(r))
+		}
+	}
+}
+
+func TestNormalizeSmartQuotes(t *testing.T) {
+	opts := DecodeOptions{NormalizeSmartQuotes: true}
+	cases := map[string]interface{}{
+		"(a:\u2018hello world\u2019,b:!(\u2018x y\u2019))": map[string]interface{}{"a": "hello world", "b": []interface{}{"x y"}},
+		"\u201chi\u201d":         `"hi"`,
+		"\u2018it!\u2019s\u2019": "it's",
+	}
+	for r, want := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+	}
+
+	r := "(a:\u2018hello world\u2019)"
+	v, err := Decode([]byte(r), Rison)
+	if err == nil {
+		t.Errorf("decoding %s : want error without the option, got %s", r, dumpValue(v))
+	}
+}
+
+func TestSortArrays(t *testing.T) {
+	opts := EncodeOptions{SortArrays: true}
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{[]int{3, 1, 2}, "!(1,2,3)"},
+		{[]float64{1.5, -2, 1e21}, "!(-2,1.5,1e21)"},
+		{[]string{"b", "c", "a b"}, "!('a b',b,c)"},
+		{[]bool{true, false, true}, "!(!f,!t,!t)"},
+		{map[string]interface{}{"s": []int{2, 1}, "t": []interface{}{[]int{9, 8}, []int{1}}}, "(s:!(1,2),t:!(!(8,9),!(1)))"},
+		{[]interface{}{2, "a", 1}, "!(2,a,1)"},
+		{[]interface{}{2, nil, 1}, "!(2,!n,1)"},
+		{[]interface{}{map[string]int{"b": 1}, map[string]int{"a": 1}}, "!((b:1),(a:1))"},
+		{[]int{}, "!()"},
+	}
+	for _, c := range cases {
+		r, err := MarshalWithOptions(c.v, Rison, opts)
+		if err != nil {
+			t.Errorf("encoding %s : want no error, got error `%s`", dumpValue(c.v), err.Error())
+			continue
+		}
+		if string(r) != c.want {
+			t.Errorf("encoding %s : want %s, got %s", dumpValue(c.v), c.want, string(r))
+		}
+	}
+
+	a, _ := MarshalWithOptions([]int{3, 1, 2}, ARison, opts)
+	b, _ := MarshalWithOptions([]int{1, 2, 3}, ARison, opts)
+	if string(a) != string(b) || string(a) != "1,2,3" {
+		t.Errorf("want the same encoding of sets, got %s and %s", string(a), string(b))
+	}
+
+	r, _ := Marshal([]int{3, 1, 2}, Rison)
+	if string(r) != "!(3,1,2)" {
+		t.Errorf("want an unsorted array without the option, got %s", string(r))
+	}
+}
+
+func TestProtoJSONCompatible(t *testing.T) {
+	opts := DecodeOptions{ProtoJSONCompatible: true}
+	cases := map[string]string{
+		"(id:9007199254740993,n:1)":   `{"id":"9007199254740993","n":1}`,
+		"!(-9223372036854775808,1.5)": `["-9223372036854775808",1.5]`,
+		"18446744073709551615":        `"18446744073709551615"`,
+		"9007199254740991":            `9007199254740991`,
+		"-9007199254740991":           `-9007199254740991`,
+		"9007199254740992":            `"9007199254740992"`,
+		"1e20":                        `100000000000000000000`,
+		"12345678901234567890.5":      `12345678901234567000`,
+	}
+	for r, want := range cases {
+		j, err := ToJSONWithOptions([]byte(r), Rison, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if string(j) != want {
+			t.Errorf("decoding %s : want %s, got %s", r, want, string(j))
+		}
+	}
+
+	var v struct {
+		ID int64 `json:"id,string"`
+	}
+	err := UnmarshalWithOptions([]byte("(id:9223372036854775807)"), &v, Rison, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != 9223372036854775807 {
+		t.Errorf("want 9223372036854775807, got %d", v.ID)
+	}
+
+	j, _ := ToJSON([]byte("9007199254740993"), Rison)
+	if string(j) != "9007199254740992" {
+		t.Errorf("want a number without the option, got %s", string(j))
+	}
+}
+
+func TestMarshalWithReport(t *testing.T) {
+	v := map[string]interface{}{
+		"id":    "abc",
+		"title": "it's a long title!",
+		"tags":  []interface{}{"x y", 12345, true},
+		"a b":   nil,
+	}
+	r, report, err := MarshalWithReport(v, ORison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "'a b':!n,id:abc,tags:!('x y',12345,!t),title:'it!'s a long title!!'"
+	if string(r) != want {
+		t.Errorf("encoding %s : want %s, got %s", dumpValue(v), want, string(r))
+	}
+	wantReport := Report{
+		QuotedStrings: 3,
+		Escapes:       2,
+		LargestPath:   ".title",
+		LargestLen:    len("'it!'s a long title!!'"),
+	}
+	if report != wantReport {
+		t.Errorf("encoding %s : want %+v, got %+v", dumpValue(v), wantReport, report)
+	}
+
+	_, report, err = MarshalWithReport(12345, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (Report{LargestPath: ".", LargestLen: 5}) {
+		t.Errorf("encoding 12345 : want the root as the largest, got %+v", report)
+	}
+}
+
+func TestFixedArrayLength(t *testing.T) {
+	var a [3]int
+	err := Unmarshal([]byte("!(1,2,3)"), &a, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != [3]int{1, 2, 3} {
+		t.Errorf("decoding !(1,2,3) : want [1 2 3], got %v", a)
+	}
+
+	type inner struct {
+		Pair [2]string `json:"pair"`
+	}
+	var s struct {
+		Items []inner `json:"items"`
+	}
+	err = Unmarshal([]byte("(items:!((pair:!(a,b)),(Pair:!(c,d))))"), &s, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Items) != 2 || s.Items[1].Pair != [2]string{"c", "d"} {
+		t.Errorf("want the pairs decoded, got %+v", s)
+	}
+
+	cases := []struct {
+		r   string
+		v   interface{}
+		pos int
+		msg string
+	}{
+		{"!(1,2)", &[3]int{}, 0, `array of 2 elements cannot be stored in "." of type [3]int with 3 elements`},
+		{"!(1,2,3,4)", &[3]int{}, 0, `array of 4 elements cannot be stored in "." of type [3]int with 3 elements`},
+		{"(items:!((pair:!(a,b)),(pair:!(c))))", &s, 29, `array of 1 elements cannot be stored in ".items[1].pair" of type [2]string with 2 elements`},
+		{"(x:!(!(1,2),!(3)))", &map[string][][2]int{}, 12, `array of 1 elements cannot be stored in ".x[1]" of type [2]int with 2 elements`},
+		{"a:!(1)", &struct{ A *[2]int }{}, 2, `array of 1 elements cannot be stored in ".a" of type [2]int with 2 elements`},
+	}
+	for _, c := range cases {
+		m := Rison
+		if c.r[0] == 'a' {
+			m = ORison
+		}
+		err := Unmarshal([]byte(c.r), c.v, m)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %v", c.r, err)
+			continue
+		}
+		if e.Type != EArrayLength || e.Pos != c.pos {
+			t.Errorf("decoding %s : want EArrayLength at %d, got %d at %d", c.r, c.pos, e.Type, e.Pos)
+		}
+		if !strings.HasPrefix(e.Error(), c.msg) {
+			t.Errorf("decoding %s : want message %s, got %s", c.r, c.msg, e.Error())
+		}
+	}
+}
+
+func TestMarshalShortest(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want string
+		mode Mode
+	}{
+		{map[string]int{"b": 2, "a": 1}, "a:1,b:2", ORison},
+		{testStruct{I: 1}, "a:!n,b:!f,f:0,i:1,p:!n,s:'',x:!n", ORison},
+		{map[string]int{}, "", ORison},
+		{[]string{"x", "y z"}, "x,'y z'", ARison},
+		{[]int{}, "", ARison},
+		{"abc", "abc", Rison},
+		{nil, "!n", Rison},
+		{1.5, "1.5", Rison},
+	}
+	for _, c := range cases {
+		r, m, err := MarshalShortest(c.v)
+		if err != nil {
+			t.Errorf("encoding %s : want no error, got error `%s`", dumpValue(c.v), err.Error())
+			continue
+		}
+		if string(r) != c.want || m != c.mode {
+			t.Errorf("encoding %s : want %s in mode %d, got %s in mode %d", dumpValue(c.v), c.want, c.mode, string(r), m)
+		}
+		if _, err := Decode(r, m); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", string(r), err.Error())
+		}
+	}
+}
+
+func TestParseErrorPath(t *testing.T) {
+	cases := map[string]string{
+		"(filters:!(a,b,(range:(min:!x))))": "$.filters[2].range.min",
+		"(filters:!(a,b,(range:(min:1,))))": "$.filters[2].range",
+		"(a:!(!(1,2),!(3,-)))":              "$.a[1][1]",
+		"('a b':(c:'d))":                    "$['a b'].c",
+		"('it!'s':!x)":                      `$['it\'s']`,
+		"(a:1,!x)":                          "$",
+		"!x":                                "$",
+	}
+	for r, want := range cases {
+		v, err := Decode([]byte(r), Rison)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %s", r, dumpValue(v))
+			continue
+		}
+		if e.Path() != want {
+			t.Errorf("decoding %s : want the path %s, got %s", r, want, e.Path())
+		}
+	}
+
+	var s struct {
+		Items []struct {
+			N    int    `json:"n"`
+			Pair [2]int `json:"pair"`
+		} `json:"items"`
+	}
+	var m struct {
+		A struct {
+			N int `json:"n"`
+		} `json:"a"`
+	}
+	err := Unmarshal([]byte("(a:(n:x))"), &m, Rison)
+	if e, ok := err.(*ParseError); !ok || e.Path() != "$.a.n" {
+		t.Errorf("want the path of the type mismatch $.a.n, got %v", err)
+	}
+	err = Unmarshal([]byte("(items:!((n:1),(pair:!(1))))"), &s, Rison)
+	if e, ok := err.(*ParseError); !ok || e.Path() != "$.items[1].pair" {
+		t.Errorf("want the path of the array $.items[1].pair, got %v", err)
+	}
+
+	// the mismatches found by "encoding/json"
+	var j struct {
+		A []struct {
+			N int `json:"n"`
+		} `json:"a"`
+		M map[string]struct {
+			N int `json:"n"`
+		} `json:"m"`
+	}
+	mismatches := []struct {
+		r    string
+		want string
+	}{
+		{"(a:!((n:1),(n:2.5)))", "$.a[1].n"},
+		{"(m:('a b':(n:2.5)))", "$.m['a b'].n"},
+	}
+	for _, c := range mismatches {
+		err = Unmarshal([]byte(c.r), &j, Rison)
+		if e, ok := err.(*ParseError); !ok || e.Type != ETypeMismatch || e.Path() != c.want {
+			t.Errorf("decoding %s : want ETypeMismatch in %s, got %v", c.r, c.want, err)
+		}
+	}
+}
+
+func TestKeyOperatorChars(t *testing.T) {
+	cases := map[string]interface{}{
+		"(availability~:disabled)": map[string]interface{}{"availability~": "disabled"},
+		"(size_gib~GE:1024,size_gib-GE:100,tags~:!(deprecated,dev))": map[string]interface{}{
+			"size_gib~GE": float64(1024),
+			"size_gib-GE": float64(100),
+			"tags~":       []interface{}{"deprecated", "dev"},
+		},
+		"(a:b~c)": map[string]interface{}{"a": "b~c"},
+	}
+	for r, want := range cases {
+		v, err := Decode([]byte(r), Rison)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+	}
+
+	opts := DecodeOptions{KeyOperatorChars: "*@:"}
+	r := "(size*:100,name@:'a*b',x:y)"
+	want := map[string]interface{}{"size*": float64(100), "name@": "a*b", "x": "y"}
+	v, err := DecodeWithOptions([]byte(r), Rison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+	}
+	for _, r := range []string{"(size*:100)", "(a:b*)", "(*a:1)"} {
+		_, err := DecodeWithOptions([]byte(r), Rison, DecodeOptions{KeyOperatorChars: "*"})
+		if err != nil && r == "(size*:100)" {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+		if err == nil && r != "(size*:100)" {
+			t.Errorf("decoding %s : want error, got none", r)
+		}
+		if _, err := Decode([]byte(r), Rison); err == nil {
+			t.Errorf("decoding %s : want error without the option, got none", r)
+		}
+	}
+}
+
+func TestEncodeCycle(t *testing.T) {
+	m := map[string]interface{}{"a": 1}
+	m["m"] = m
+	n := &Node{Kind: KindArray}
+	n.Children = []*Node{n}
+	type list struct {
+		Next *list `json:"next"`
+	}
+	l := &list{}
+	l.Next = l
+	for _, v := range []interface{}{m, n, l, []interface{}{m}} {
+		r, err := Marshal(v, Rison)
+		if err == nil {
+			t.Errorf("encoding a cyclic %T : want an error, got %.20s..", v, r)
+			continue
+		}
+		msg := err.Error()
+		if !strings.Contains(msg, "encountered a cycle") {
+			t.Errorf("encoding a cyclic %T : want the cycle error, got %.100s", v, msg)
+		}
+		if 20000 < len(msg) {
+			t.Errorf("encoding a cyclic %T : want a short error, got %d bytes", v, len(msg))
+		}
+	}
+}
+
+func TestEncodeMaxDepth(t *testing.T) {
+	nest := func(depth int) interface{} {
+		var v interface{} = "leaf"
+		for i := 0; i < depth; i++ {
+			if i%2 == 0 {
+				v = map[string]interface{}{"a": v}
+			} else {
+				v = []interface{}{v}
+			}
+		}
+		return v
+	}
+	opts := EncodeOptions{MaxDepth: 4}
+	for depth := 0; depth <= 4; depth++ {
+		if _, err := MarshalWithOptions(nest(depth), Rison, opts); err != nil {
+			t.Errorf("encoding %d levels : want no error, got error `%s`", depth, err.Error())
+		}
+	}
+	_, err := MarshalWithOptions(nest(5), Rison, opts)
+	if err == nil || !strings.Contains(err.Error(), "nesting depth exceeds 4 at .a[0].a[0]") {
+		t.Errorf("encoding 5 levels : want the depth error, got %v", err)
+	}
+	if _, err := Marshal(nest(100), Rison); err != nil {
+		t.Errorf("encoding 100 levels : want no error without the option, got error `%s`", err.Error())
+	}
+
+	type node struct {
+		Name string `json:"name"`
+		Next *node  `json:"next"`
+	}
+	n := &node{Name: "loop"}
+	n.Next = n
+	_, err = MarshalWithOptions(n, Rison, opts)
+	if err == nil || !strings.Contains(err.Error(), "nesting depth exceeds 4 at .next.next.next.next") {
+		t.Errorf("encoding a recursive value : want the depth error, got %v", err)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	opts := DecodeOptions{MaxKeys: 3}
+	cases := map[string]int{
+		"(a:1,b:2,c:3,d:4,e:5)":   13,
+		"(x:(a:1,b:2,c:3,'d':4))": 16,
+		"(a:1,a:1,a:1,a:1)":       13,
+	}
+	for r, pos := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %s", r, dumpValue(v))
+			continue
+		}
+		if e.Type != EKeysExceeded || e.Pos != pos {
+			t.Errorf("decoding %s : want EKeysExceeded at %d, got %d at %d", r, pos, e.Type, e.Pos)
+		}
+	}
+
+	for _, r := range []string{"(a:1,b:2,c:3)", "(a:(a:1,b:2,c:3),b:(d:1,e:2,f:3),c:!((a:1,b:2,c:3)))"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+	r := "a:1,b:2,c:3,d:4"
+	_, err := DecodeWithOptions([]byte(r), ORison, opts)
+	if e, ok := err.(*ParseError); !ok || e.Type != EKeysExceeded || e.Pos != 12 {
+		t.Errorf("decoding %s : want EKeysExceeded at 12, got %v", r, err)
+	}
+}
+
+func TestZeroTimeAsNull(t *testing.T) {
+	type event struct {
+		Name    string     `json:"name"`
+		Start   time.Time  `json:"start"`
+		End     time.Time  `json:"end"`
+		Removed *time.Time `json:"removed"`
+	}
+	zero := time.Time{}
+	v := event{
+		Name:    "x",
+		Start:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Removed: &zero,
+	}
+	opts := EncodeOptions{ZeroTimeAsNull: true}
+	r, err := MarshalWithOptions(v, Rison, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(end:!n,name:x,removed:!n,start:'2020-01-02T03:04:05Z')"
+	if string(r) != want {
+		t.Errorf("encoding %s : want %s, got %s", dumpValue(v), want, string(r))
+	}
+
+	var decoded event
+	err = Unmarshal(r, &decoded, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Start.Equal(v.Start) || !decoded.End.IsZero() || decoded.Removed != nil {
+		t.Errorf("decoding %s : want %+v, got %+v", string(r), v, decoded)
+	}
+
+	r, err = Marshal(v, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "(end:'0001-01-01T00:00:00Z',name:x,removed:'0001-01-01T00:00:00Z',start:'2020-01-02T03:04:05Z')"
+	if string(r) != want {
+		t.Errorf("encoding %s : want %s without the option, got %s", dumpValue(v), want, string(r))
+	}
+}
+
+func TestOmitZero(t *testing.T) {
+	type inner struct {
+		A int `json:"a"`
+	}
+	type s struct {
+		T time.Time  `json:"t,omitzero"`
+		N int        `json:"n,omitzero"`
+		P *time.Time `json:"p,omitzero"`
+		I inner      `json:"i,omitzero"`
+		E []int      `json:"e,omitzero"`
+	}
+	zero := time.Time{}
+	cases := []s{
+		{},
+		{P: &zero, E: []int{}},
+		{T: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), N: 1, I: inner{2}},
+	}
+	for _, v := range cases {
+		j, _ := json.Marshal(v)
+		want, _ := FromJSON(j, Rison)
+		r, err := Marshal(v, Rison)
+		if err != nil || string(r) != string(want) {
+			t.Errorf("encoding %s : want %s, got %s, %v", j, want, r, err)
+		}
+	}
+}
+
+func TestFloatMapKeys(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{map[float64]int{1.5: 1, 100: 2, 1e21: 3, 1e-7: 4}, "('1.5':1,'100':2,'1e+21':3,'1e-7':4)"},
+		{map[float32]int{1.1: 1}, "('1.1':1)"},
+	}
+	for _, c := range cases {
+		r, err := Marshal(c.v, Rison)
+		if err != nil || string(r) != c.want {
+			t.Errorf("encoding %#v : want %s, got %s, %v", c.v, c.want, r, err)
+		}
+	}
+}
+
+type testStatus string
+
+func (s *testStatus) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "active", "pending":
+		*s = testStatus(text)
+		return nil
+	}
+	return fmt.Errorf("unknown status %s", string(text))
+}
+
+func TestTextUnmarshalerMapKeys(t *testing.T) {
+	var m map[testStatus]int
+	err := Unmarshal([]byte("(active:1,pending:2)"), &m, Rison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[testStatus]int{"active": 1, "pending": 2}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("want %v, got %v", want, m)
+	}
+
+	var s struct {
+		Counts map[testStatus]int `json:"counts"`
+	}
+	cases := map[string]int{
+		"(counts:(active:1,'on hold':2))": 18,
+		"(counts:(closed:1))":             9,
+	}
+	for r, pos := range cases {
+		err := Unmarshal([]byte(r), &s, Rison)
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("decoding %s : want *ParseError, got %v", r, err)
+			continue
+		}
+		if e.Type != EInvalidKey || e.Pos != pos || e.Path() != "$.counts" {
+			t.Errorf("decoding %s : want EInvalidKey at %d in $.counts, got %d at %d in %s", r, pos, e.Type, e.Pos, e.Path())
+		}
+		if !strings.HasPrefix(e.Error(), `invalid key "`) || !strings.Contains(e.Error(), "for rison.testStatus: unknown status") {
+			t.Errorf("decoding %s : want the message citing the key, got %s", r, e.Error())
+		}
+	}
+}
+
+// testPoint is written as the text "x/y".
+type testPoint struct {
+	X, Y int
+}
+
+func (p testPoint) MarshalText() ([]byte, error) {
+	if p.X < 0 {
+		return nil, fmt.Errorf("negative x %d", p.X)
+	}
+	return []byte(fmt.Sprintf("%d/%d", p.X, p.Y)), nil
+}
+
+func (p *testPoint) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d/%d", &p.X, &p.Y)
+	return err
+}
+
+func TestTextMarshaler(t *testing.T) {
+	type shape struct {
+		Origin testPoint         `json:"origin"`
+		Center *testPoint        `json:"center"`
+		Path   []testPoint       `json:"path"`
+		Labels map[testPoint]int `json:"labels"`
+	}
+	v := shape{
+		Origin: testPoint{1, 2},
+		Center: &testPoint{3, 4},
+		Path:   []testPoint{{5, 6}, {7, 8}},
+		Labels: map[testPoint]int{{9, 10}: 1},
+	}
+	want := "(center:'3/4',labels:('9/10':1),origin:'1/2',path:!('5/6','7/8'))"
+	r, err := Marshal(v, Rison)
+	if err != nil || string(r) != want {
+		t.Fatalf("encoding %+v : want %s, got %s, %v", v, want, r, err)
+	}
+	tree, err := EncodeTree(map[string]interface{}{"p": testPoint{1, 2}}, Rison)
+	if err != nil || string(tree) != "(p:'1/2')" {
+		t.Errorf("encoding the tree : want (p:'1/2'), got %s, %v", tree, err)
+	}
+	tree, err = EncodeTree(map[string]interface{}{"p": (*testPoint)(nil), "t": (*time.Time)(nil)}, Rison)
+	if err != nil || string(tree) != "(p:!n,t:!n)" {
+		t.Errorf("encoding the tree of nil pointers : want (p:!n,t:!n), got %s, %v", tree, err)
+	}
+
+	var decoded shape
+	err = Unmarshal(r, &decoded, Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if !reflect.DeepEqual(decoded, v) {
+		t.Errorf("decoding %s : want %+v, got %+v", r, v, decoded)
+	}
+
+	if _, err := Marshal(shape{Origin: testPoint{-1, 0}}, Rison); err == nil || !strings.Contains(err.Error(), "negative x -1") {
+		t.Errorf("encoding a negative point : want the error of MarshalText, got %v", err)
+	}
+	if err := Unmarshal([]byte("(origin:'1-2')"), &decoded, Rison); err == nil {
+		t.Errorf("decoding (origin:'1-2') : want the error of UnmarshalText, got nil")
+	}
+}
+
+func TestEncodeNumberFormat(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{1e20, "100000000000000000000"},
+		{999999999999999900000.0, "999999999999999900000"},
+		{1e21, "1e21"},
+		{-1e21, "-1e21"},
+		{1.5e21, "1.5e21"},
+		{1e100, "1e100"},
+		{1e-6, "0.000001"},
+		{1e-7, "1e-7"},
+		{0.0000001, "1e-7"},
+		{-1.5e-7, "-1.5e-7"},
+		{0.1, "0.1"},
+		{float32(0.1), "0.1"},
+		{float32(1e21), "1e21"},
+		{100.0, "100"},
+		{-0.0, "0"},
+		{int64(-9223372036854775808), "-9223372036854775808"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{json.Number("1E+21"), "1e21"},
+	}
+	for _, c := range cases {
+		r, err := Marshal(c.v, Rison)
+		if err != nil {
+			t.Errorf("encoding %#v : want no error, got error `%s`", c.v, err.Error())
+			continue
+		}
+		if string(r) != c.want {
+			t.Errorf("encoding %#v : want %s, got %s", c.v, c.want, string(r))
+		}
+	}
+}
+
+func TestAllowComments(t *testing.T) {
+	opts := DecodeOptions{AllowComments: true}
+	want := map[string]interface{}{
+		"name":  "web #1",
+		"url":   "http://example.com/a//b",
+		"ports": []interface{}{float64(80), float64(443)},
+		"tls":   true,
+	}
+	r := `# the server config
+(
+	name: 'web #1', // the name
+	url: 'http://example.com/a//b', /* quoted, so not a comment */
+	ports: !(
+		80, # http
+		/* https */ 443
+	),
+	tls: !t
+)
+// the end`
+	v, err := DecodeWithOptions([]byte(r), Rison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+	}
+
+	r = "a:x/y, # comment\nb:1 // comment"
+	v, err = DecodeWithOptions([]byte(r), ORison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if want := map[string]interface{}{"a": "x/y", "b": float64(1)}; !reflect.DeepEqual(v, want) {
+		t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+	}
+
+	for _, r := range []string{"(a:1 # comment\n)", "(a:1/*c*/)", "!(1 /* unterminated)"} {
+		if _, err := Decode([]byte(r), Rison); err == nil {
+			t.Errorf("decoding %s : want error without the option, got none", r)
+		}
+	}
+	unterminated := []struct {
+		r   string
+		m   Mode
+		pos int
+	}{
+		{"!(1 /* unterminated)", Rison, 4},
+		{"(a:1) /* x", Rison, 6},
+		{"a:1 /* x", ORison, 4},
+		{"1,2 /* x", ARison, 4},
+	}
+	for _, c := range unterminated {
+		_, err := DecodeWithOptions([]byte(c.r), c.m, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EUnmatchedPair || e.Pos != c.pos {
+			t.Errorf("decoding %s : want EUnmatchedPair at %d for the unterminated comment, got %#v", c.r, c.pos, err)
+		}
+	}
+}
+
+func TestEncodeTree(t *testing.T) {
+	for r := range testCases {
+		tree, err := Decode([]byte(r), Rison)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Marshal(tree, Rison)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := EncodeTree(tree, Rison)
+		if err != nil {
+			t.Errorf("encoding %s : want no error, got error `%s`", dumpValue(tree), err.Error())
+			continue
+		}
+		if string(got) != string(want) {
+			t.Errorf("encoding %s : want %s, got %s", dumpValue(tree), string(want), string(got))
+		}
+	}
+
+	tree := map[string]interface{}{"a": []interface{}{json.Number("1.50"), "x y"}}
+	r, err := EncodeTree(tree, ORison)
+	if err != nil || string(r) != "a:!(1.50,'x y')" {
+		t.Errorf("encoding %s : want a:!(1.50,'x y'), got %s, %v", dumpValue(tree), string(r), err)
+	}
+	if _, err := EncodeTree([]interface{}{1}, ORison); err == nil {
+		t.Errorf("want an error for an array in the O-Rison")
+	}
+	_, err = EncodeTree(map[string]interface{}{"a": []interface{}{1}}, Rison)
+	if err == nil || !strings.Contains(err.Error(), "int value at .a[0]") {
+		t.Errorf("want an error for an int in a tree, got %v", err)
+	}
+}
+
+func benchmarkTree() interface{} {
+	items := []interface{}{}
+	for i := 0; i < 100; i++ {
+		items = append(items, map[string]interface{}{
+			"id":   float64(i),
+			"name": fmt.Sprintf("item %d", i),
+			"tags": []interface{}{"a", "b", true, nil},
+		})
+	}
+	return map[string]interface{}{"items": items, "total": float64(len(items))}
+}
+
+func BenchmarkMarshalTree(b *testing.B) {
+	tree := benchmarkTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(tree, Rison); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeTree(b *testing.B) {
+	tree := benchmarkTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeTree(tree, Rison); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeTo(b *testing.B) {
+	tree := benchmarkTree()
+	var dst []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		dst, err = EncodeTo(dst[:0], tree, Rison)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncodeTo(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		m    Mode
+		want string
+	}{
+		{testStruct{I: 1, S: "a b", A: []int64{2}}, Rison, "(a:!(2),b:!f,f:0,i:1,p:!n,s:'a b',x:!n)"},
+		{map[string]int{"b": 2, "a": 1}, ORison, "a:1,b:2"},
+		{[]string{"x", "y"}, ARison, "x,y"},
+		{"str", Rison, "str"},
+	}
+	for _, c := range cases {
+		dst := []byte("q=")
+		r, err := EncodeTo(dst, c.v, c.m)
+		if err != nil || string(r) != "q="+c.want {
+			t.Errorf("encoding %s : want q=%s, got %s, %v", dumpValue(c.v), c.want, r, err)
+		}
+	}
+
+	dst := make([]byte, 0, 64)
+	for i := 0; i < 3; i++ {
+		var err error
+		dst, err = EncodeTo(dst, i, Rison)
+		if err != nil {
+			t.Errorf("encoding %d : want no error, got error `%s`", i, err.Error())
+		}
+		dst = append(dst, ',')
+	}
+	if string(dst) != "0,1,2," {
+		t.Errorf("appending 0 to 2 : want 0,1,2, got %s", dst)
+	}
+
+	dst = []byte("q=")
+	r, err := EncodeTo(dst, func() {}, Rison)
+	if err == nil || string(r) != "q=" {
+		t.Errorf("encoding a func : want an error with dst, got %s, %v", r, err)
+	}
+	r, err = EncodeTo(nil, "x", ORison)
+	if err == nil || r != nil {
+		t.Errorf("encoding x in the O-Rison : want an error, got %s, %v", r, err)
+	}
+
+	tree := benchmarkTree()
+	want, _ := Marshal(tree, Rison)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dst []byte
+			for j := 0; j < 20; j++ {
+				r, err := EncodeTo(dst[:0], tree, Rison)
+				if err != nil || !bytes.Equal(r, want) {
+					t.Errorf("encoding concurrently : want %.20s.., got %.20s.., %v", want, r, err)
+					return
+				}
+				dst = r
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDecodeAutoObject(t *testing.T) {
+	want := map[string]interface{}{"a": float64(1), "b": []interface{}{"x", "y z"}}
+	for _, r := range []string{"(a:1,b:!(x,'y z'))", "a:1,b:!(x,'y z')"} {
+		v, err := DecodeAutoObject([]byte(r))
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+	}
+	for _, r := range []string{"()", ""} {
+		v, err := DecodeAutoObject([]byte(r))
+		if err != nil || v == nil || len(v) != 0 {
+			t.Errorf("decoding %s : want an empty map, got %s, %v", r, dumpValue(v), err)
+		}
+	}
+	for _, r := range []string{"abc", "1", "!n", "!(a:1)", "'a:1'", "(a:1"} {
+		v, err := DecodeAutoObject([]byte(r))
+		if err == nil {
+			t.Errorf("decoding %s : want error, got %s", r, dumpValue(v))
+		}
+	}
+}
+
+func TestIsBareString(t *testing.T) {
+	for c := 0; c < 256; c++ {
+		b := byte(c)
+		notChar := 0 <= strings.IndexByte(" '!:(),*@$", b)
+		notStart := notChar || b == '-' || '0' <= b && b <= '9'
+		if got := IsBareStringChar(b, false); got == notChar {
+			t.Errorf("IsBareStringChar(%q, false) : want %v, got %v", b, !notChar, got)
+		}
+		if got := IsBareStringChar(b, true); got == notStart {
+			t.Errorf("IsBareStringChar(%q, true) : want %v, got %v", b, !notStart, got)
+		}
+	}
+
+	cases := map[string]bool{
+		"a":      true,
+		"a-1":    true,
+		"_x.y~z": true,
+		"日本語":    true,
+		"a/b":    true,
+		"":       false,
+		"-a":     false,
+		"0a":     false,
+		"9":      false,
+		"a b":    false,
+		"a'":     false,
+		"a!":     false,
+		"a:b":    false,
+		"(a":     false,
+		"a)":     false,
+		"a,b":    false,
+		"*a":     false,
+		"a@b":    false,
+		"$a":     false,
+		"a\xff":  false,
+	}
+	for s, want := range cases {
+		if got := IsBareString(s); got != want {
+			t.Errorf("IsBareString(%q) : want %v, got %v", s, want, got)
+		}
+		if !want {
+			continue
+		}
+		r, err := Marshal(s, Rison)
+		if err != nil || string(r) != s {
+			t.Errorf("encoding %q : want it bare, got %s, %v", s, r, err)
+		}
+	}
+}
+
+func TestNeedsQuoting(t *testing.T) {
+	cases := map[string]bool{
+		"abc":       false,
+		"a-b.c_d~e": false,
+		"a1":        false,
+		"G.":        false,
+		"日本語":       false,
+		"":          true,
+		"a b":       true,
+		"it's":      true,
+		"a:b":       true,
+		"(x)":       true,
+		"a,b":       true,
+		"a*b":       true,
+		"1":         true,
+		"-1":        true,
+		"1.5e3":     true,
+		"-x":        true,
+		"!t":        true,
+		"!n":        true,
+		"a!":        true,
+	}
+	for s, want := range cases {
+		if got := NeedsQuoting(s); got != want {
+			t.Errorf("NeedsQuoting(%q) : want %v, got %v", s, want, got)
+		}
+		r, err := Marshal(s, Rison)
+		if err != nil {
+			t.Errorf("encoding %q : want no error, got error `%s`", s, err.Error())
+			continue
+		}
+		if quoted := strings.HasPrefix(string(r), "'"); quoted != want {
+			t.Errorf("encoding %q : want quoted %v, got %s", s, want, r)
+		}
+	}
+}
+
+func TestDecodeToSyncMap(t *testing.T) {
+	sm, err := DecodeToSyncMap([]byte("(host:example.com,port:8080,tags:!(a,b))"), Rison)
+	if err != nil {
+		t.Fatalf("decoding : want no error, got error `%s`", err.Error())
+	}
+	want := map[string]interface{}{
+		"host": "example.com",
+		"port": float64(8080),
+		"tags": []interface{}{"a", "b"},
+	}
+	got := map[string]interface{}{}
+	sm.Range(func(k, v interface{}) bool {
+		got[k.(string)] = v
+		return true
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoding : want %s, got %s", dumpValue(want), dumpValue(got))
+	}
+	if v, ok := sm.Load("port"); !ok || v != float64(8080) {
+		t.Errorf("loading port : want 8080, got %v, %v", v, ok)
+	}
+
+	sm, err = DecodeToSyncMap([]byte("a:1"), ORison)
+	if err != nil {
+		t.Errorf("decoding a:1 : want no error, got error `%s`", err.Error())
+	} else if v, _ := sm.Load("a"); v != float64(1) {
+		t.Errorf("decoding a:1 : want 1, got %v", v)
+	}
+	for _, r := range []string{"1", "abc", "!n", "!(a)", "(a:1"} {
+		if sm, err := DecodeToSyncMap([]byte(r), Rison); err == nil {
+			t.Errorf("decoding %s : want error, got %v", r, sm)
+		}
+	}
+}
+
+func TestSkipWhitespaces(t *testing.T) {
+	opts := DecodeOptions{SkipWhitespaces: true}
+	cases := []struct {
+		r    string
+		m    Mode
+		want interface{}
+	}{
+		{"( a : 1 , b : 2 )", Rison, map[string]interface{}{"a": float64(1), "b": float64(2)}},
+		{"(\n\ta:1,\n\tb:'x y'\n)", Rison, map[string]interface{}{"a": float64(1), "b": "x y"}},
+		{"!( 1 , 'x y' , !t , ( ) , !( ) )", Rison, []interface{}{float64(1), "x y", true, map[string]interface{}{}, []interface{}{}}},
+		{" \r\n(a:1)\r\n ", Rison, map[string]interface{}{"a": float64(1)}},
+		{" 1.5 ", Rison, 1.5},
+		{" abc ", Rison, "abc"},
+		{" a : 1 , b : !( x ) ", ORison, map[string]interface{}{"a": float64(1), "b": []interface{}{"x"}}},
+		{" 1 , 2 ", ARison, []interface{}{float64(1), float64(2)}},
+	}
+	for _, c := range cases {
+		v, err := DecodeWithOptions([]byte(c.r), c.m, opts)
+		if err != nil {
+			t.Errorf("decoding %q : want no error, got error `%s`", c.r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, c.want) {
+			t.Errorf("decoding %q : want %s, got %s", c.r, dumpValue(c.want), dumpValue(v))
+		}
+		if v, err := Decode([]byte(c.r), c.m); err == nil {
+			t.Errorf("decoding %q without the option : want error, got %s", c.r, dumpValue(v))
+		}
+	}
+
+	j, err := ToJSONWithOptions([]byte("( a : !( 1 ) )"), Rison, opts)
+	if err != nil || string(j) != `{"a":[1]}` {
+		t.Errorf(`decoding ( a : !( 1 ) ) : want {"a":[1]}, got %s, %v`, j, err)
+	}
+	var s testStruct
+	err = UnmarshalWithOptions([]byte("( i : 1 , s : x )"), &s, Rison, opts)
+	if err != nil || s.I != 1 || s.S != "x" {
+		t.Errorf("decoding ( i : 1 , s : x ) : want i:1 and s:x, got %+v, %v", s, err)
+	}
+
+	for _, r := range []string{"a b", "( a:1 ) x", "! t", "!( 1 2 )", "' a '  b"} {
+		if v, err := DecodeWithOptions([]byte(r), Rison, opts); err == nil {
+			t.Errorf("decoding %q : want error, got %s", r, dumpValue(v))
+		}
+	}
+}
+
+func TestIPAddresses(t *testing.T) {
+	type network struct {
+		IP     netip.Addr   `json:"ip"`
+		CIDR   netip.Prefix `json:"cidr"`
+		Legacy net.IP       `json:"legacy"`
+	}
+	cases := []struct {
+		n network
+		r string
+	}{
+		{
+			network{netip.MustParseAddr("192.168.0.1"), netip.MustParsePrefix("10.0.0.0/8"), net.ParseIP("172.16.0.1")},
+			"(cidr:'10.0.0.0/8',ip:'192.168.0.1',legacy:'172.16.0.1')",
+		},
+		{
+			network{netip.MustParseAddr("fe80::1%eth0"), netip.MustParsePrefix("2001:db8::/32"), net.ParseIP("::1")},
+			"(cidr:'2001:db8::/32',ip:'fe80::1%eth0',legacy:'::1')",
+		},
+		{
+			network{netip.MustParseAddr("::ffff:10.0.0.1"), netip.MustParsePrefix("::/0"), nil},
+			"(cidr:'::/0',ip:'::ffff:10.0.0.1',legacy:'')",
+		},
+	}
+	for _, c := range cases {
+		r, err := Marshal(c.n, Rison)
+		if err != nil {
+			t.Errorf("encoding %+v : want no error, got error `%s`", c.n, err.Error())
+			continue
+		}
+		if string(r) != c.r {
+			t.Errorf("encoding %+v : want %s, got %s", c.n, c.r, r)
+		}
+		var n network
+		err = Unmarshal(r, &n, Rison)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if n.IP != c.n.IP || n.CIDR != c.n.CIDR || !n.Legacy.Equal(c.n.Legacy) {
+			t.Errorf("decoding %s : want %+v, got %+v", r, c.n, n)
+		}
+	}
+
+	// IPv4 addresses start with a digit and IPv6 ones contain ":",
+	// so neither can be bare.
+	for _, s := range []string{"192.168.0.1", "10.0.0.0/8", "::1", "fe80::1", "2001:db8::/32"} {
+		if !NeedsQuoting(s) {
+			t.Errorf("NeedsQuoting(%q) : want true, got false", s)
+		}
+	}
+
+	var n network
+	err := Unmarshal([]byte("(ip:'256.0.0.1')"), &n, Rison)
+	if err == nil {
+		t.Errorf("decoding (ip:'256.0.0.1') : want error, got %+v", n)
+	}
+}
+
+func TestEscapeForString(t *testing.T) {
+	inner := "(q:'it!'s',tags:!(a,!t),x:!n)"
+	e := EscapeForString([]byte(inner))
+	want := "(q:!'it!!!'s!',tags:!!(a,!!t),x:!!n)"
+	if string(e) != want {
+		t.Errorf("escaping %s : want %s, got %s", inner, want, e)
+	}
+	outer := "(sub:'" + string(e) + "')"
+	v, err := Decode([]byte(outer), Rison)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", outer, err.Error())
+	}
+	sub := v.(map[string]interface{})["sub"]
+	if sub != inner {
+		t.Errorf("decoding %s : want %s, got %s", outer, inner, dumpValue(sub))
+	}
+	r, err := Marshal(map[string]string{"sub": inner}, Rison)
+	if err != nil || string(r) != outer {
+		t.Errorf("encoding %s : want %s, got %s, %v", inner, outer, r, err)
+	}
+	if e := EscapeForString(nil); len(e) != 0 {
+		t.Errorf("escaping the empty data : want empty, got %s", e)
+	}
+}
+
+func TestMaxStringLen(t *testing.T) {
+	opts := DecodeOptions{MaxStringLen: 5}
+	for _, r := range []string{"abcde", "'abcde'", "'ab!'!!'", "(abcde:'a b c')", "!(abc,'',x)"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+	cases := map[string]int{
+		"abcdef":             0,
+		"'abcdef'":           0,
+		"'ab!'!!cd'":         0,
+		"(a:1,abcdef:1)":     5,
+		"!(x,(a:'a b c d'))": 7,
+	}
+	for r, pos := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EStringTooLong {
+			t.Errorf("decoding %s : want EStringTooLong, got %s, %v", r, dumpValue(v), err)
+			continue
+		}
+		if e.Pos != pos {
+			t.Errorf("decoding %s : want the error at %d, got %d", r, pos, e.Pos)
+		}
+	}
+
+	long := strings.Repeat("a", 1<<20)
+	_, err := DecodeWithOptions([]byte("(k:"+long+")"), Rison, opts)
+	if e, ok := err.(*ParseError); !ok || e.Type != EStringTooLong || e.Pos != 3 {
+		t.Errorf("decoding a long id : want EStringTooLong at 3, got %v", err)
+	}
+	if _, err := Decode([]byte(long), Rison); err != nil {
+		t.Errorf("decoding a long id without the limit : want no error, got error `%s`", err.Error())
+	}
+}
+
+func TestUnquoteString(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	for i := 0; i < 256; i++ {
+		buf.WriteByte(byte(i))
+	}
+	for _, s := range []string{buf.String(), "(花:上野,🍣:'🐟 x')", ""} {
+		qs := QuoteString(s)
+		u, err := UnquoteString(qs)
+		if err != nil {
+			t.Errorf("unescaping %s : want %q, got error `%s`", qs, s, err.Error())
+		} else if u != s {
+			t.Errorf("unescaping %s : want %q, got %q", qs, s, u)
+		}
+		b, err := Unquote(Quote([]byte(s)))
+		if err != nil || string(b) != s {
+			t.Errorf("unescaping %s : want %q, got %q, %v", qs, s, b, err)
+		}
+	}
+	for _, qs := range []string{"%", "a%2", "%zz", "(a:%G0)"} {
+		if u, err := UnquoteString(qs); err == nil {
+			t.Errorf("unescaping %s : want error, got %q", qs, u)
+		}
+		if u, err := Unquote([]byte(qs)); err == nil || u != nil {
+			t.Errorf("unescaping %s : want error, got %q", qs, u)
+		}
+	}
+}
+
+// testColor is a type implementing only fmt.Stringer.
+type testColor int
+
+func (c testColor) String() string {
+	return [...]string{"red", "green", "blue"}[c]
+}
+
+func TestUseStringer(t *testing.T) {
+	type config struct {
+		Color   testColor      `json:"color"`
+		Colors  []testColor    `json:"colors"`
+		Pattern *regexp.Regexp `json:"pattern"`
+		Timeout time.Duration  `json:"timeout"`
+		Ptr     *testColor     `json:"ptr"`
+	}
+	blue := testColor(2)
+	c := config{
+		Color:   1,
+		Colors:  []testColor{0, 2},
+		Pattern: regexp.MustCompile(`^a(b|c)+$`),
+		Timeout: 90 * time.Second,
+		Ptr:     &blue,
+	}
+	r, err := MarshalWithOptions(c, Rison, EncodeOptions{UseStringer: true})
+	want := "(color:green,colors:!(red,blue),pattern:'^a(b|c)+$',ptr:blue,timeout:'1m30s')"
+	if err != nil || string(r) != want {
+		t.Errorf("encoding %+v : want %s, got %s, %v", c, want, r, err)
+	}
+
+	r, err = Marshal(c, Rison)
+	want = "(color:1,colors:!(0,2),pattern:'^a(b|c)+$',ptr:2,timeout:90000000000)"
+	if err != nil || string(r) != want {
+		t.Errorf("encoding %+v without the option : want %s, got %s, %v", c, want, r, err)
+	}
+
+	c.Pattern = nil
+	c.Ptr = nil
+	r, err = MarshalWithOptions(c, Rison, EncodeOptions{UseStringer: true})
+	want = "(color:green,colors:!(red,blue),pattern:!n,ptr:!n,timeout:'1m30s')"
+	if err != nil || string(r) != want {
+		t.Errorf("encoding %+v : want %s, got %s, %v", c, want, r, err)
+	}
+}
+
+func TestAllowDoubleQuotes(t *testing.T) {
+	opts := DecodeOptions{AllowDoubleQuotes: true}
+	cases := map[string]string{
+		`(a:"hello")`:            `{"a":"hello"}`,
+		`("a b":"it's \"x\"\n")`: `{"a b":"it's \"x\"\n"}`,
+		`!("",'x',"あ\/!(")`:      `["","x","あ/!("]`,
+		`(a:a"b,'c"':"d'")`:      `{"a":"a\"b","c\"":"d'"}`,
+		`"🍣"`:                    `"🍣"`,
+	}
+	for r, want := range cases {
+		j, err := ToJSONWithOptions([]byte(r), Rison, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if string(j) != want {
+			t.Errorf("decoding %s : want %s, got %s", r, want, j)
+		}
+	}
+	j, err := ToJSON([]byte(`(a:"hello")`), Rison)
+	if err != nil || string(j) != `{"a":"\"hello\""}` {
+		t.Errorf(`decoding (a:"hello") without the option : want {"a":"\"hello\""}, got %s, %v`, j, err)
+	}
+
+	errs := map[string]ErrType{
+		`(a:"x)`:   EUnmatchedPair,
+		`"a\x"`:    EInvalidStringEscape,
+		`"a\u12"`:  EInvalidStringEscape,
+		"\"a\tb\"": EInvalidCharacter,
+		`"a\`:      EMissingCharacterAfterEscape,
+		`"ab"c`:    EExtraCharacterAfterRison,
+	}
+	for r, typ := range errs {
+		_, err := ToJSONWithOptions([]byte(r), Rison, opts)
+		if e, ok := err.(*ParseError); !ok || e.Type != typ {
+			t.Errorf("decoding %q : want error type %d, got %v", r, typ, err)
+		}
+	}
+	_, err = ToJSONWithOptions([]byte(`("":1)`), Rison, DecodeOptions{AllowDoubleQuotes: true, DisallowEmptyKeys: true})
+	if e, ok := err.(*ParseError); !ok || e.Type != EEmptyKey {
+		t.Errorf(`decoding ("":1) : want EEmptyKey, got %v`, err)
+	}
+}
+
+// decodeViaJSON decodes r through the JSON encoding, as Decode did
+// before building the tree directly.
+func decodeViaJSON(r []byte, m Mode, opts DecodeOptions) (interface{}, error) {
+	j, err := ToJSONWithOptions(r, m, opts)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(j, &v)
+	return v, err
+}
+
+func TestDecodeDirect(t *testing.T) {
+	for r := range testCases {
+		want, err := decodeViaJSON([]byte(r), Rison, DecodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := Decode([]byte(r), Rison)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+	}
+
+	opts := DecodeOptions{
+		ProtoJSONCompatible: true,
+		LiteralAliases:      map[string]interface{}{"yes": true, "none": nil, "pi": 3.14},
+	}
+	for _, r := range []string{"!(9007199254740993,yes,none,pi,(a:1,a:2))", "(x:!(),y:())"} {
+		want, _ := decodeViaJSON([]byte(r), Rison, opts)
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil || !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s, %v", r, dumpValue(want), dumpValue(v), err)
+		}
+	}
+}
+
+func BenchmarkDecodeViaJSON(b *testing.B) {
+	r, _ := Marshal(benchmarkTree(), Rison)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeViaJSON(r, Rison, DecodeOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	r, _ := Marshal(benchmarkTree(), Rison)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(r, Rison); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestInternCommonValues(t *testing.T) {
+	opts := DecodeOptions{InternCommonValues: true}
+	for _, r := range []string{"!(0,1,255,256,-0,-1,1.5,1e2,!t,!f,!n,'',(a:1))", "(a:!(1,1,2),b:(c:0))"} {
+		want, _ := Decode([]byte(r), Rison)
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil || !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s, %v", r, dumpValue(want), dumpValue(v), err)
+		}
+	}
+	v, _ := DecodeWithOptions([]byte("!(-0)"), Rison, opts)
+	if f := v.([]interface{})[0].(float64); !math.Signbit(f) {
+		t.Errorf("decoding !(-0) : want -0, got %v", f)
+	}
+}
+
+func benchmarkSmallValues() []byte {
+	var b strings.Builder
+	b.WriteString("!(")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "(id:%d,n:!(0,1,2),ok:!t,ng:!f,x:!n,s:''),", i%256)
+	}
+	b.WriteString("0)")
+	return []byte(b.String())
+}
+
+func BenchmarkDecodeSmallValues(b *testing.B) {
+	r := benchmarkSmallValues()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(r, Rison); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeSmallValuesInterned(b *testing.B) {
+	r := benchmarkSmallValues()
+	opts := DecodeOptions{InternCommonValues: true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeWithOptions(r, Rison, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	opts := DecodeOptions{UseNumber: true}
+	r := "(id:9007199254740993,big:-18446744073709551615,f:1.50,e:1e3,n:!(0,-0))"
+	v, err := DecodeWithOptions([]byte(r), Rison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	want := map[string]interface{}{
+		"id":  json.Number("9007199254740993"),
+		"big": json.Number("-18446744073709551615"),
+		"f":   json.Number("1.50"),
+		"e":   json.Number("1e3"),
+		"n":   []interface{}{json.Number("0"), json.Number("-0")},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+	}
+
+	j, err := ToJSONWithOptions([]byte(r), Rison, opts)
+	wantJSON := `{"id":9007199254740993,"big":-18446744073709551615,"f":1.50,"e":1e3,"n":[0,-0]}`
+	if err != nil || string(j) != wantJSON {
+		t.Errorf("decoding %s : want %s, got %s, %v", r, wantJSON, j, err)
+	}
+
+	var s struct {
+		ID  int64       `json:"id"`
+		U   uint64      `json:"u"`
+		Any interface{} `json:"any"`
+	}
+	r = "(id:9007199254740993,u:18446744073709551615,any:9007199254740995)"
+	err = UnmarshalWithOptions([]byte(r), &s, Rison, opts)
+	if err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if s.ID != 9007199254740993 || s.U != 18446744073709551615 || s.Any != json.Number("9007199254740995") {
+		t.Errorf("decoding %s : want the exact integers, got %+v", r, s)
+	}
+	r = "(id:9007199254740993,any:9007199254740995)"
+	err = Unmarshal([]byte(r), &s, Rison)
+	if err != nil || s.ID == 9007199254740993 || s.Any != float64(9007199254740995) {
+		t.Errorf("decoding %s without the option : want the rounded integers, got %+v, %v", r, s, err)
+	}
+
+	err = UnmarshalWithOptions([]byte("(id:x)"), &s, Rison, opts)
+	if e, ok := err.(*ParseError); !ok || e.Type != ETypeMismatch || e.Pos != 4 {
+		t.Errorf("decoding (id:x) : want ETypeMismatch at 4, got %v", err)
+	}
+}
+
+func TestKeyPriority(t *testing.T) {
+	m := map[string]interface{}{"a": 1, "z": 2, "type": "range", "id": 3, "nested": map[string]int{"x": 1, "type": 2}}
+	cases := []struct {
+		priority []string
+		want     string
+	}{
+		{nil, "(a:1,id:3,nested:(type:2,x:1),type:range,z:2)"},
+		{[]string{"type"}, "(type:range,a:1,id:3,nested:(type:2,x:1),z:2)"},
+		{[]string{"z", "type", "missing"}, "(z:2,type:range,a:1,id:3,nested:(type:2,x:1))"},
+		{[]string{"x", "id", "x"}, "(id:3,a:1,nested:(x:1,type:2),type:range,z:2)"},
+	}
+	for _, c := range cases {
+		r, err := MarshalWithOptions(m, Rison, EncodeOptions{KeyPriority: c.priority})
+		if err != nil || string(r) != c.want {
+			t.Errorf("encoding with %v : want %s, got %s, %v", c.priority, c.want, r, err)
+		}
+	}
+
+	s := testStruct{I: 1, S: "x"}
+	r, err := MarshalWithOptions(s, ORison, EncodeOptions{KeyPriority: []string{"s", "i"}})
+	want := "s:x,i:1,a:!n,b:!f,f:0,p:!n,x:!n"
+	if err != nil || string(r) != want {
+		t.Errorf("encoding %+v : want %s, got %s, %v", s, want, r, err)
+	}
+}
+
+func TestAllowedKeys(t *testing.T) {
+	allowed := regexp.MustCompile(`^[a-z]+$`)
+	opts := EncodeOptions{AllowedKeys: allowed.MatchString}
+	r, err := MarshalWithOptions(map[string]interface{}{"a": 1, "b": map[string]int{"c": 2}}, Rison, opts)
+	if want := "(a:1,b:(c:2))"; err != nil || string(r) != want {
+		t.Errorf("encoding with AllowedKeys : want %s, got %s, %v", want, r, err)
+	}
+
+	s := struct {
+		ID int            `json:"ID"`
+		M  map[string]int `json:"m"`
+	}{ID: 1, M: map[string]int{"ok": 1, "Secret": 2}}
+	_, err = MarshalWithOptions(s, Rison, opts)
+	want := `key "Secret" is not allowed at .m`
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("encoding %+v : want error `%s`, got %v", s, want, err)
+	}
+	_, err = MarshalWithOptions(map[string]int{"x-y": 1}, Rison, opts)
+	want = `key "x-y" is not allowed at .`
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("encoding (x-y:1) : want error `%s`, got %v", want, err)
+	}
+
+	type withRest struct {
+		N     int                    `json:"n"`
+		Extra map[string]interface{} `json:"-" rison:",rest"`
+	}
+	rs := struct {
+		R withRest `json:"r"`
+	}{withRest{Extra: map[string]interface{}{"secret": 1}}}
+	opts.AllowedKeys = func(k string) bool { return k != "secret" }
+	_, err = MarshalWithOptions(rs, Rison, opts)
+	want = `key "secret" is not allowed at .r`
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("encoding %+v : want error `%s`, got %v", rs, want, err)
+	}
+	rs.R.Extra = map[string]interface{}{"public": 1}
+	r, err = MarshalWithOptions(rs, Rison, opts)
+	if want := "(r:(n:0,public:1))"; err != nil || string(r) != want {
+		t.Errorf("encoding %+v : want %s, got %s, %v", rs, want, r, err)
+	}
+}
+
+func TestMaxInputBytes(t *testing.T) {
+	opts := DecodeOptions{MaxInputBytes: 10}
+	for _, r := range []string{"(a:1,b:22)", "'abcdefgh'", "1"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+	if _, err := DecodeWithOptions([]byte("a:1,bc:22"), ORison, opts); err != nil {
+		t.Errorf("decoding a:1,bc:22 : want no error with the wrapping not counted, got error `%s`", err.Error())
+	}
+
+	for _, m := range []Mode{Rison, ORison, ARison} {
+		r := "(a:1,b:33)"
+		if m == ARison {
+			r = "1,22,333,4"
+		} else if m == ORison {
+			r = "a:1,b:4444"
+		}
+		r += "5"
+		v, err := DecodeWithOptions([]byte(r), m, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EInputTooLong || e.Pos != 10 {
+			t.Errorf("decoding %s : want EInputTooLong at 10, got %s, %v", r, dumpValue(v), err)
+			continue
+		}
+		want := "input of 11 bytes longer than 10 bytes"
+		if !strings.HasPrefix(e.Error(), want) {
+			t.Errorf("decoding %s : want the error `%s`, got %s", r, want, e.Error())
+		}
+	}
+
+	var v interface{}
+	long := []byte("(s:'" + strings.Repeat("x", 100) + "')")
+	if err := UnmarshalWithOptions(long, &v, Rison, DecodeOptions{MaxInputBytes: 100, MaxStringLen: 200}); !errors.Is(err, EInputTooLong) {
+		t.Errorf("decoding a long input : want EInputTooLong, got %v", err)
+	}
+	if err := UnmarshalWithOptions(long, &v, Rison, DecodeOptions{MaxInputBytes: 200, MaxStringLen: 99}); !errors.Is(err, EStringTooLong) {
+		t.Errorf("decoding a long string : want EStringTooLong, got %v", err)
+	}
+	if err := UnmarshalWithOptions(long, &v, Rison, DecodeOptions{}); err != nil {
+		t.Errorf("decoding a long input without limits : want no error, got error `%s`", err.Error())
+	}
+}
+
+func TestMaxTotalStringBytes(t *testing.T) {
+	opts := DecodeOptions{MaxTotalStringBytes: 10}
+	for _, r := range []string{"(ab:cd,ef:'gh')", "!(a,b,c,d,e,f,g,h,i,j)", "!(1,2,!t,!n,())"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("!(")
+	for i := 0; i < 1000; i++ {
+		if 0 < i {
+			b.WriteByte(',')
+		}
+		b.WriteString("ab")
+	}
+	b.WriteString(")")
+	cases := map[string]int{
+		b.String():             17,
+		"(ab:cd,ef:'gh',ij:k)": 18,
+		"!('aaaaaaaaaa','b')":  15,
+	}
+	for r, pos := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EStringBytesExceeded {
+			t.Errorf("decoding %.20s : want EStringBytesExceeded, got %s, %v", r, dumpValue(v), err)
+			continue
+		}
+		if e.Pos != pos {
+			t.Errorf("decoding %.20s : want the error at %d, got %d", r, pos, e.Pos)
+		}
+	}
+
+	ps := NewParser(Rison, opts)
+	for i := 0; i < 3; i++ {
+		ps.Reset([]byte("!(abcde,fghij)"))
+		if _, err := ps.Decode(); err != nil {
+			t.Errorf("decoding !(abcde,fghij) again : want no error, got error `%s`", err.Error())
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for r := range testCases {
+		if err := Validate([]byte(r), Rison); err != nil {
+			t.Errorf("validating %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+	for _, rs := range invalidDecodeCases {
+		r, ok := rs.([]byte)
+		if !ok {
+			r = []byte(rs.(string))
+		}
+		err := Validate(r, Rison)
+		_, want := Decode(r, Rison)
+		if err == nil || want == nil || err.Error() != want.Error() {
+			t.Errorf("validating %s : want error `%v`, got `%v`", r, want, err)
+		}
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("validating %s : want *ParseError, got %#v", r, err)
+		}
+	}
+	if err := Validate([]byte("a:1,b:!(x)"), ORison); err != nil {
+		t.Errorf("validating a:1,b:!(x) : want no error, got error `%s`", err.Error())
+	}
+	if err := Validate([]byte("a:1)"), ARison); err == nil {
+		t.Errorf("validating a:1) : want error, got nil")
+	}
+}
+
+func TestMarshalJSONString(t *testing.T) {
+	v := map[string]interface{}{"q": `say "hi" <b>`, "n": []interface{}{1.5, nil, "\\"}}
+	j, err := MarshalJSONString(v, Rison)
+	want := `"(n:!(1.5,!n,\\),q:'say \"hi\" \u003cb\u003e')"`
+	if err != nil || string(j) != want {
+		t.Errorf("encoding %s : want %s, got %s, %v", dumpValue(v), want, j, err)
+	}
+
+	doc := []byte(`{"filter":` + string(j) + `}`)
+	var outer struct {
+		Filter string `json:"filter"`
+	}
+	if err := json.Unmarshal(doc, &outer); err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", doc, err.Error())
+	}
+	r, _ := Marshal(v, Rison)
+	if outer.Filter != string(r) {
+		t.Errorf("decoding %s : want %s, got %s", doc, r, outer.Filter)
+	}
+	decoded, err := Decode([]byte(outer.Filter), Rison)
+	if err != nil || !reflect.DeepEqual(decoded, v) {
+		t.Errorf("decoding %s : want %s, got %s, %v", outer.Filter, dumpValue(v), dumpValue(decoded), err)
+	}
+
+	j, err = MarshalJSONString([]int{1, 2}, ARison)
+	if err != nil || string(j) != `"1,2"` {
+		t.Errorf(`encoding [1,2] : want "1,2", got %s, %v`, j, err)
+	}
+	if j, err := MarshalJSONString(1, ORison); err == nil {
+		t.Errorf("encoding 1 in the O-Rison : want error, got %s", j)
+	}
+}
+
+func TestRequireMinimalNumbers(t *testing.T) {
+	opts := DecodeOptions{RequireMinimalNumbers: true}
+	for _, r := range []string{"1", "100", "-1.5", "0.1", "1e21", "1.5e-7", "-0", "!(0,12345678901234567890)"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+
+	cases := map[string]int{
+		"1.0":       0,
+		"1e2":       0,
+		"1.50":      0,
+		"!(1,1e0)":  4,
+		"(a:2.500)": 3,
+		"1e-0":      0,
+	}
+	for r, pos := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != ENonMinimalNumber {
+			t.Errorf("decoding %s : want ENonMinimalNumber, got %s, %v", r, dumpValue(v), err)
+			continue
+		}
+		if e.Pos != pos {
+			t.Errorf("decoding %s : want the error at %d, got %d", r, pos, e.Pos)
+		}
+		if _, err := Decode([]byte(r), Rison); err != nil {
+			t.Errorf("decoding %s without the option : want no error, got error `%s`", r, err.Error())
+		}
+	}
+}
+
+func TestAllowUppercaseExponent(t *testing.T) {
+	cases := map[string]interface{}{
+		"1.5E2":      float64(150),
+		"1E30":       float64(1e30),
+		"-2E-3":      float64(-0.002),
+		"!(1E2,1e2)": []interface{}{float64(100), float64(100)},
+		"(a:1.5E2)":  map[string]interface{}{"a": float64(150)},
+	}
+	opts := DecodeOptions{AllowUppercaseExponent: true}
+	for r, want := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+
+		if _, err = Decode([]byte(r), Rison); err == nil {
+			t.Errorf("decoding %s without the option : want an error, got nil", r)
+		}
+	}
+
+	_, err := Decode([]byte("1.5E2"), Rison)
+	if e, ok := err.(*ParseError); !ok || e.Type != EInvalidLargeExp {
+		t.Errorf("decoding 1.5E2 without the option : want EInvalidLargeExp, got %v", err)
+	}
+}
+
+func TestAllowPlusExponent(t *testing.T) {
+	cases := map[string]interface{}{
+		"1e+30":         float64(1e30),
+		"1e+0":          float64(1),
+		"1.5e+2":        float64(150),
+		"-2e-3":         float64(-0.002),
+		"!(1e+2,(a:1))": []interface{}{float64(100), map[string]interface{}{"a": float64(1)}},
+	}
+	opts := DecodeOptions{AllowPlusExponent: true}
+	for r, want := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(want), dumpValue(v))
+		}
+		if _, err = Decode([]byte(r), Rison); err == nil && strings.Contains(r, "+") {
+			t.Errorf("decoding %s without the option : want an error, got nil", r)
+		}
+	}
+
+	for _, r := range []string{"1e++2", "1e-+2", "1+e2", "1e+"} {
+		if v, err := DecodeWithOptions([]byte(r), Rison, opts); err == nil {
+			t.Errorf("decoding %s : want an error, got %s", r, dumpValue(v))
+		}
+	}
+}
+
+func TestASCIIRoundTrip(t *testing.T) {
+	for b := 0; b < 128; b++ {
+		for _, s := range []string{string([]byte{byte(b)}), "a" + string([]byte{byte(b)}) + "z"} {
+			m := map[string]string{"k": s}
+			r, err := Marshal(m, Rison)
+			if err != nil {
+				t.Errorf("encoding %q : want no error, got error `%s`", s, err.Error())
+				continue
+			}
+			var got map[string]string
+			err = Unmarshal(r, &got, Rison)
+			if err != nil {
+				t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+				continue
+			}
+			if got["k"] != s {
+				t.Errorf("decoding %s : want %q, got %q", r, s, got["k"])
+			}
+			v, err := Decode(r, Rison)
+			if err != nil || !reflect.DeepEqual(v, map[string]interface{}{"k": s}) {
+				t.Errorf("decoding %s : want %q, got %s, %v", r, s, dumpValue(v), err)
+			}
+		}
+	}
+}
+
+func TestMarshalIndent(t *testing.T) {
+	b := true
+	values := []interface{}{
+		testStruct{I: 1, S: "a,b (c) !d 'e'", P: &b, A: []int64{1, 2}, X: map[string]interface{}{"y": []interface{}{}, "z": map[string]interface{}{}}},
+		map[string]interface{}{"!(": "!(", "')": []interface{}{"'", ")", ","}},
+		[]interface{}{[]interface{}{[]interface{}{1.5}}, nil, true, "", "x y"},
+		"plain",
+	}
+	opts := DecodeOptions{SkipWhitespaces: true}
+	for _, v := range values {
+		want, err := Marshal(v, Rison)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := MarshalIndent(v, Rison, " ", "\t")
+		if err != nil {
+			t.Errorf("encoding %s : want no error, got error `%s`", want, err.Error())
+			continue
+		}
+		got, err := DecodeWithOptions(r, Rison, opts)
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		orig, _ := Decode(want, Rison)
+		if !reflect.DeepEqual(got, orig) {
+			t.Errorf("decoding %s : want %s, got %s", r, dumpValue(orig), dumpValue(got))
+		}
+	}
+
+	r, err := MarshalIndent(map[string]int{"a": 1, "b": 2}, ORison, "", " ")
+	if want := "a:1,\nb:2"; err != nil || string(r) != want {
+		t.Errorf("encoding (a:1,b:2) to the O-Rison : want %q, got %q, %v", want, r, err)
+	}
+	r, err = MarshalIndent([]interface{}{1, []int{2}}, ARison, "", " ")
+	if want := "1,\n!(\n 2\n)"; err != nil || string(r) != want {
+		t.Errorf("encoding !(1,!(2)) to the A-Rison : want %q, got %q, %v", want, r, err)
+	}
+	if _, err := MarshalIndent(func() {}, Rison, "", " "); err == nil {
+		t.Errorf("encoding a func : want an error, got nil")
+	}
+}
+
+func TestDisallowDuplicateKeys(t *testing.T) {
+	opts := DecodeOptions{DisallowDuplicateKeys: true}
+	for _, r := range []string{"(a:1,b:2)", "(a:(a:1),b:(a:2))", "!((a:1),(a:2))", "(a:!((a:1)),'':1)"} {
+		if _, err := DecodeWithOptions([]byte(r), Rison, opts); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+
+	cases := map[string]int{
+		"(a:1,a:2)":         5,
+		"(a:1,b:2,'a':3)":   9,
+		"(x:(a:1,b:2,b:3))": 12,
+		"('':1,'':2)":       6,
+	}
+	for r, pos := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EDuplicateKey {
+			t.Errorf("decoding %s : want EDuplicateKey, got %s, %v", r, dumpValue(v), err)
+			continue
+		}
+		if e.Pos != pos {
+			t.Errorf("decoding %s : want the error at %d, got %d", r, pos, e.Pos)
+		}
+		if _, err := Decode([]byte(r), Rison); err != nil {
+			t.Errorf("decoding %s without the option : want no error, got error `%s`", r, err.Error())
+		}
+	}
+
+	_, err := DecodeWithOptions([]byte("a:1,a:2"), ORison, opts)
+	want := `duplicate object key "a" (at [4] near "a:1," -> "a" -> ":2")`
+	if err == nil || err.Error() != want {
+		t.Errorf("decoding a:1,a:2 : want the error `%s`, got %v", want, err)
+	}
+	v, _ := Decode([]byte("(a:1,a:2)"), Rison)
+	if !reflect.DeepEqual(v, map[string]interface{}{"a": float64(2)}) {
+		t.Errorf("decoding (a:1,a:2) without the option : want the last value, got %s", dumpValue(v))
+	}
+}
+
+func TestReportLeadingZeros(t *testing.T) {
+	opts := DecodeOptions{ReportLeadingZeros: true}
+	for r, want := range map[string]float64{"0": 0, "-0": 0, "0.5": 0.5, "-0.05": -0.05, "0e3": 0, "10": 10, "1e01": 10} {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		if err != nil || v != want {
+			t.Errorf("decoding %s : want %v, got %s, %v", r, want, dumpValue(v), err)
+		}
+	}
+
+	cases := map[string]int{
+		"01":        0,
+		"-01":       1,
+		"00":        0,
+		"00.5":      0,
+		"!(1,-007)": 5,
+	}
+	for r, pos := range cases {
+		v, err := DecodeWithOptions([]byte(r), Rison, opts)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != ELeadingZero {
+			t.Errorf("decoding %s : want ELeadingZero, got %s, %v", r, dumpValue(v), err)
+			continue
+		}
+		if e.Pos != pos {
+			t.Errorf("decoding %s : want the error at %d, got %d", r, pos, e.Pos)
+		}
+		_, err = Decode([]byte(r), Rison)
+		if e, ok := err.(*ParseError); !ok || e.Type != EInvalidNumber {
+			t.Errorf("decoding %s without the option : want EInvalidNumber, got %v", r, err)
+		}
+	}
+
+	_, err := DecodeWithOptions([]byte("a:-01"), ORison, opts)
+	want := `number "-01" must not have leading zeros (at [3] near "a:-" -> "0" -> "1")`
+	if err == nil || err.Error() != want {
+		t.Errorf("decoding a:-01 : want the error `%s`, got %v", want, err)
+	}
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	type doc struct {
+		S     testStruct             `json:"s"`
+		Items []item                 `json:"items"`
+		M     map[string]item        `json:"m"`
+		Rest  map[string]interface{} `json:"-" rison:",rest"`
+	}
+	for _, r := range []string{"(i:1,s:x)", "(I:1,S:x)", "()", "(x:(any:1))"} {
+		var v testStruct
+		if err := UnmarshalStrict([]byte(r), &v, Rison); err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+		}
+	}
+	var d doc
+	r := "(s:(i:1),items:!((name:a)),m:(k:(name:b)),other:1)"
+	if err := UnmarshalStrict([]byte(r), &d, Rison); err != nil || d.Rest["other"] != float64(1) {
+		t.Errorf("decoding %s : want other in the rest, got %+v, %v", r, d, err)
+	}
+
+	cases := []struct {
+		r   string
+		v   interface{}
+		pos int
+	}{
+		{"(i:1,y:2)", &testStruct{}, 5},
+		{"(s:(i:1,extra:x))", &doc{}, 8},
+		{"(items:!((name:a),(name:b,n:1)))", &doc{}, 26},
+		{"(m:(k:(nam:b)))", &doc{}, 7},
+		{"!((i:1),(j:1))", &[]testStruct{}, 9},
+	}
+	for _, c := range cases {
+		err := UnmarshalStrict([]byte(c.r), c.v, Rison)
+		e, ok := err.(*ParseError)
+		if !ok || e.Type != EUnknownField {
+			t.Errorf("decoding %s : want EUnknownField, got %v", c.r, err)
+			continue
+		}
+		if e.Pos != c.pos {
+			t.Errorf("decoding %s : want the error at %d, got %d", c.r, c.pos, e.Pos)
+		}
+		if err := Unmarshal([]byte(c.r), c.v, Rison); err != nil {
+			t.Errorf("decoding %s without the option : want no error, got error `%s`", c.r, err.Error())
+		}
+	}
+
+	var v testStruct
+	err := UnmarshalStrict([]byte("i:1,y:2"), &v, ORison)
+	want := `unknown key "y" for rison.testStruct (at [4] near "i:1," -> "y" -> ":2")`
+	if err == nil || err.Error() != want {
+		t.Errorf("decoding i:1,y:2 : want the error `%s`, got %v", want, err)
+	}
+}
+
+func TestKeepFieldOrder(t *testing.T) {
+	type inner struct {
+		Z int `json:"z"`
+		A int `json:"a"`
+	}
+	type query struct {
+		Name  string                 `json:"name"`
+		Min   int                    `json:"min"`
+		Max   int                    `json:"max,omitempty"`
+		Inner inner                  `json:"inner"`
+		M     map[string]int         `json:"m"`
+		Rest  map[string]interface{} `json:"-" rison:",rest"`
+	}
+	v := query{Name: "x", Min: 1, Inner: inner{2, 3}, M: map[string]int{"b": 1, "a": 2}, Rest: map[string]interface{}{"y": 1.0, "b": 2.0}}
+	cases := []struct {
+		opts EncodeOptions
+		want string
+	}{
+		{EncodeOptions{}, "(b:2,inner:(a:3,z:2),m:(a:2,b:1),min:1,name:x,y:1)"},
+		{EncodeOptions{KeepFieldOrder: true}, "(name:x,min:1,inner:(z:2,a:3),m:(a:2,b:1),b:2,y:1)"},
+		{EncodeOptions{KeepFieldOrder: true, KeyPriority: []string{"m", "y"}}, "(m:(a:2,b:1),y:1,name:x,min:1,inner:(z:2,a:3),b:2)"},
+	}
+	for _, c := range cases {
+		r, err := MarshalWithOptions(v, Rison, c.opts)
+		if err != nil || string(r) != c.want {
+			t.Errorf("encoding with %+v : want %s, got %s, %v", c.opts, c.want, r, err)
+		}
+	}
+
+	var decoded query
+	r, _ := MarshalWithOptions(v, Rison, EncodeOptions{KeepFieldOrder: true})
+	if err := Unmarshal(r, &decoded, Rison); err != nil || !reflect.DeepEqual(decoded, v) {
+		t.Errorf("decoding %s : want %+v, got %+v, %v", r, v, decoded, err)
+	}
+}
+
+func TestBareStringPredicate(t *testing.T) {
+	noDots := func(s string) bool { return !strings.Contains(s, ".") }
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{"domain.com", "'domain.com'"},
+		{"domain", "domain"},
+		{"1.5", "'1.5'"},
+		{"a b", "'a b'"},
+		{map[string]interface{}{"host.name": "domain.com", "port": 80}, "('host.name':'domain.com',port:80)"},
+		{[]interface{}{"x.y", "it's", "z"}, "!('x.y','it!'s',z)"},
+	}
+	for _, c := range cases {
+		r, err := MarshalWithOptions(c.v, Rison, EncodeOptions{BareStringPredicate: noDots})
+		if err != nil || string(r) != c.want {
+			t.Errorf("encoding %s : want %s, got %s, %v", dumpValue(c.v), c.want, r, err)
+		}
+	}
+
+	r, err := MarshalWithOptions("domain.com", Rison, EncodeOptions{})
+	if err != nil || string(r) != "domain.com" {
+		t.Errorf("encoding domain.com without the predicate : want domain.com, got %s, %v", r, err)
+	}
+	r, err = MarshalWithOptions("-1", Rison, EncodeOptions{BareStringPredicate: func(string) bool { return true }})
+	if err != nil || string(r) != "'-1'" {
+		t.Errorf("encoding -1 allowed bare : want '-1', got %s, %v", r, err)
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	type doc struct {
+		ID    int               `json:"id"`
+		Body  json.RawMessage   `json:"body"`
+		Empty json.RawMessage   `json:"empty"`
+		Ptr   *json.RawMessage  `json:"ptr"`
+		List  []json.RawMessage `json:"list"`
+	}
+	ptr := json.RawMessage(`"p q"`)
+	v := doc{
+		ID:   1,
+		Body: json.RawMessage(`{"b": [1, "x y", true], "a": null}`),
+		Ptr:  &ptr,
+		List: []json.RawMessage{json.RawMessage(`1e3`), json.RawMessage(`{}`)},
+	}
+	want := "(body:(a:!n,b:!(1,'x y',!t)),empty:!n,id:1,list:!(1000,()),ptr:'p q')"
+	r, err := Marshal(v, Rison)
+	if err != nil || string(r) != want {
+		t.Fatalf("encoding %+v : want %s, got %s, %v", v, want, r, err)
+	}
+
+	var decoded doc
+	if err := Unmarshal(r, &decoded, Rison); err != nil {
+		t.Fatalf("decoding %s : want no error, got error `%s`", r, err.Error())
+	}
+	if string(decoded.Body) != `{"a":null,"b":[1,"x y",true]}` || decoded.Ptr == nil || string(*decoded.Ptr) != `"p q"` {
+		t.Errorf("decoding %s : want the JSON of the values, got %s and %v", r, decoded.Body, decoded.Ptr)
+	}
+
+	_, err = Marshal(map[string]interface{}{"x": json.RawMessage(`{bad`)}, Rison)
+	if err == nil || !strings.Contains(err.Error(), "invalid character") {
+		t.Errorf("encoding invalid JSON : want the error of the JSON, got %v", err)
+	}
+	_, err = Marshal(struct {
+		R json.RawMessage `json:"r"`
+	}{json.RawMessage(`{bad`)}, Rison)
+	if err == nil || !strings.Contains(err.Error(), " at .r ") {
+		t.Errorf("encoding invalid JSON in a field : want the error at .r, got %v", err)
+	}
+}
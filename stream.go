@@ -0,0 +1,123 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// An Encoder writes Rison values to an output stream.
+type Encoder struct {
+	w              io.Writer
+	mode           Mode
+	lengthPrefixed bool
+}
+
+// NewEncoder returns a new encoder that writes to w in the mode m.
+func NewEncoder(w io.Writer, m Mode) *Encoder {
+	return &Encoder{w: w, mode: m}
+}
+
+// LengthPrefixed makes the encoder write each value preceded by its
+// length in bytes as a 4-byte big-endian unsigned integer.
+//
+// Unlike separating values by whitespace, the framing is unambiguous
+// even if the values contain whitespace in quoted strings.
+func (enc *Encoder) LengthPrefixed() {
+	enc.lengthPrefixed = true
+}
+
+// Encode writes the Rison encoding of v to the stream.
+// See Marshal for the details.
+func (enc *Encoder) Encode(v interface{}) error {
+	r, err := Marshal(v, enc.mode)
+	if err != nil {
+		return err
+	}
+	if enc.lengthPrefixed {
+		if math.MaxUint32 < uint64(len(r)) {
+			return fmt.Errorf("the Rison of %d bytes is too long to be length-prefixed", len(r))
+		}
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(r)))
+		_, err = enc.w.Write(l[:])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = enc.w.Write(r)
+	return err
+}
+
+// defaultMaxFrameBytes is the default limit of the length of a frame
+// read by the Decoder with LengthPrefixed.
+const defaultMaxFrameBytes = 16 << 20
+
+// A Decoder reads and decodes Rison values from an input stream.
+type Decoder struct {
+	r              io.Reader
+	mode           Mode
+	lengthPrefixed bool
+	maxFrameBytes  int
+}
+
+// NewDecoder returns a new decoder that reads from r in the mode m.
+func NewDecoder(r io.Reader, m Mode) *Decoder {
+	return &Decoder{r: r, mode: m, maxFrameBytes: defaultMaxFrameBytes}
+}
+
+// LengthPrefixed makes the decoder read each value preceded by its
+// length as written by the Encoder with LengthPrefixed.
+// Otherwise, the decoder reads the whole stream as a single value.
+func (dec *Decoder) LengthPrefixed() {
+	dec.lengthPrefixed = true
+}
+
+// SetMaxFrameBytes sets the limit of the length of a frame read with
+// LengthPrefixed, 16 MiB by default. A longer frame fails without
+// reading it, since the length in the header is not to be trusted.
+func (dec *Decoder) SetMaxFrameBytes(n int) {
+	dec.maxFrameBytes = n
+}
+
+// Decode reads the next Rison-encoded value from the stream and
+// stores it in the value pointed to by v. See Unmarshal for the
+// details. It returns io.EOF at the end of the stream.
+func (dec *Decoder) Decode(v interface{}) error {
+	var data []byte
+	var err error
+	if dec.lengthPrefixed {
+		data, err = dec.readFrame()
+	} else {
+		data, err = io.ReadAll(dec.r)
+		if err == nil && len(data) == 0 {
+			err = io.EOF
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return Unmarshal(data, v, dec.mode)
+}
+
+func (dec *Decoder) readFrame() ([]byte, error) {
+	var l [4]byte
+	_, err := io.ReadFull(dec.r, l[:])
+	if err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if uint64(dec.maxFrameBytes) < uint64(n) {
+		return nil, fmt.Errorf("the frame of %d bytes exceeds the limit of %d bytes", n, dec.maxFrameBytes)
+	}
+	// the buffer grows as the data arrives, not to allocate the length
+	// in the header for a short stream
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, dec.r, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
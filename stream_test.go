@@ -0,0 +1,175 @@
+package rison
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLengthPrefixed(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"a": "x y", "b": []interface{}{1.0, "'quoted'"}},
+		[]interface{}{},
+		"white space",
+		nil,
+		3.5,
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		enc := NewEncoder(pw, Rison)
+		enc.LengthPrefixed()
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	dec := NewDecoder(pr, Rison)
+	dec.LengthPrefixed()
+	for _, want := range values {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("decoding %s : want no error, got error `%s`", dumpValue(want), err.Error())
+		}
+		if !reflect.DeepEqual(want, v) {
+			t.Errorf("decoding : want %s, got %s", dumpValue(want), dumpValue(v))
+		}
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Errorf("decoding : want io.EOF, got %#v", err)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, Rison)
+	enc.LengthPrefixed()
+	if err := enc.Encode("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte("\x00\x00\x00\x03abc")) {
+		t.Errorf("encoding abc : want \\x00\\x00\\x00\\x03abc, got %q", buf.String())
+	}
+
+	truncated := [][]byte{[]byte("\x00\x00"), []byte("\x00\x00\x00\x03ab")}
+	for _, b := range truncated {
+		dec := NewDecoder(bytes.NewReader(b), Rison)
+		dec.LengthPrefixed()
+		if err := dec.Decode(&v); err != io.ErrUnexpectedEOF {
+			t.Errorf("decoding %q : want io.ErrUnexpectedEOF, got %#v", b, err)
+		}
+	}
+
+	// the length in the header is limited, not to be trusted
+	for _, b := range [][]byte{[]byte("\xff\xff\xff\xffab"), []byte("\x01\x00\x00\x01ab")} {
+		dec := NewDecoder(bytes.NewReader(b), Rison)
+		dec.LengthPrefixed()
+		if err := dec.Decode(&v); err == nil || err == io.ErrUnexpectedEOF {
+			t.Errorf("decoding %q : want an error on the length, got %#v", b, err)
+		}
+	}
+	dec = NewDecoder(bytes.NewReader(buf.Bytes()), Rison)
+	dec.LengthPrefixed()
+	dec.SetMaxFrameBytes(2)
+	if err := dec.Decode(&v); err == nil {
+		t.Errorf("decoding %q with the limit 2 : want an error, got nil", buf.Bytes())
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	for r, j := range testCases {
+		var want interface{}
+		if err := json.Unmarshal([]byte(j), &want); err != nil {
+			t.Fatal(err)
+		}
+		readers := map[string]io.Reader{
+			"strings.Reader": strings.NewReader(r),
+			"chunked reader": iotest.OneByteReader(strings.NewReader(r)),
+		}
+		for name, rd := range readers {
+			var v interface{}
+			err := NewDecoder(rd, Rison).Decode(&v)
+			if err != nil {
+				t.Errorf("decoding %s from %s : want no error, got error `%s`", r, name, err.Error())
+				continue
+			}
+			if !reflect.DeepEqual(v, want) {
+				t.Errorf("decoding %s from %s : want %s, got %s", r, name, dumpValue(want), dumpValue(v))
+			}
+		}
+	}
+
+	for _, r := range []string{"(a:1", "!(1,,2)", "(a:'x)", "(a:1)x", "!z"} {
+		_, want := Decode([]byte(r), Rison)
+		var v interface{}
+		err := NewDecoder(iotest.OneByteReader(strings.NewReader(r)), Rison).Decode(&v)
+		var pe, we *ParseError
+		if !errors.As(err, &pe) || !errors.As(want, &we) {
+			t.Errorf("decoding %s : want a *ParseError, got %#v", r, err)
+			continue
+		}
+		if pe.Type != we.Type || pe.Pos != we.Pos || pe.Error() != we.Error() {
+			t.Errorf("decoding %s : want %s, got %s", r, we.Error(), pe.Error())
+		}
+	}
+
+	var v interface{}
+	if err := NewDecoder(strings.NewReader(""), Rison).Decode(&v); err != io.EOF {
+		t.Errorf("decoding the empty stream : want io.EOF, got %#v", err)
+	}
+	var o map[string]interface{}
+	err := NewDecoder(strings.NewReader("a:1,b:x"), ORison).Decode(&o)
+	if err != nil || !reflect.DeepEqual(o, map[string]interface{}{"a": float64(1), "b": "x"}) {
+		t.Errorf("decoding a:1,b:x : want (a:1,b:x), got %s, %v", dumpValue(o), err)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	for _, j := range testCases {
+		var v interface{}
+		if err := json.Unmarshal([]byte(j), &v); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range []Mode{Rison, ORison, ARison} {
+			want, wantErr := Marshal(v, m)
+			var buf bytes.Buffer
+			err := NewEncoder(&buf, m).Encode(v)
+			if (err == nil) != (wantErr == nil) {
+				t.Errorf("encoding %s in mode %d : want error %v, got %v", j, m, wantErr, err)
+				continue
+			}
+			if err != nil {
+				if err.Error() != wantErr.Error() {
+					t.Errorf("encoding %s in mode %d : want error `%s`, got `%s`", j, m, wantErr.Error(), err.Error())
+				}
+				if buf.Len() != 0 {
+					t.Errorf("encoding %s in mode %d : want nothing written on error, got %s", j, m, buf.Bytes())
+				}
+				continue
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("encoding %s in mode %d : want %s, got %s", j, m, want, buf.Bytes())
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, Rison)
+	for _, v := range []interface{}{testStruct{I: 1}, []int{1, 2}, "a b"} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("encoding %s : want no error, got error `%s`", dumpValue(v), err.Error())
+		}
+	}
+	want := "(a:!n,b:!f,f:0,i:1,p:!n,s:'',x:!n)!(1,2)'a b'"
+	if buf.String() != want {
+		t.Errorf("encoding the values : want %s, got %s", want, buf.String())
+	}
+}
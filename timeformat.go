@@ -0,0 +1,88 @@
+package rison
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the format of time.Time values in Rison.
+type TimeFormat int
+
+const (
+	// TimeRFC3339 is the format of the strings such as
+	// '2006-01-02T15:04:05Z', as "encoding/json" does.
+	TimeRFC3339 TimeFormat = iota
+
+	// TimeUnixSeconds is the format of the numbers of seconds since the
+	// Unix epoch, such as 1136214245, which is shorter in URLs.
+	// The fraction of a second is written in decimal, such as
+	// 1136214245.5, and the location is lost; the times are decoded
+	// in UTC.
+	TimeUnixSeconds
+)
+
+// appendUnixSeconds appends the number of seconds of t since the Unix
+// epoch in decimal, without the trailing zeros of the fraction.
+func appendUnixSeconds(b []byte, t time.Time) []byte {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	if nsec == 0 {
+		return strconv.AppendInt(b, sec, 10)
+	}
+	if sec < 0 {
+		sec, nsec = sec+1, 1e9-nsec
+		b = append(b, '-')
+		b = strconv.AppendInt(b, -sec, 10)
+	} else {
+		b = strconv.AppendInt(b, sec, 10)
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%09d", nsec), "0")
+	return append(append(b, '.'), frac...)
+}
+
+// parseUnixSeconds parses the number of seconds since the Unix epoch
+// written in any form of JSON numbers.
+func parseUnixSeconds(s string) (time.Time, bool) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return time.Time{}, false
+	}
+	ns := new(big.Int).Quo(new(big.Int).Mul(r.Num(), big.NewInt(1e9)), r.Denom())
+	sec, nsec := new(big.Int).DivMod(ns, big.NewInt(1e9), new(big.Int))
+	if !sec.IsInt64() {
+		return time.Time{}, false
+	}
+	return time.Unix(sec.Int64(), nsec.Int64()).UTC(), true
+}
+
+// needsTimeConversion reports whether a value of type t can hold a time.Time.
+func needsTimeConversion(t reflect.Type, seen map[reflect.Type]bool) bool {
+	// *time.Time decodes itself as well as time.Time
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	if decodesItself(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return needsTimeConversion(t.Elem(), seen)
+	case reflect.Struct:
+		for _, f := range structFields(t) {
+			if needsTimeConversion(f.typ, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package rison
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimeFormat(t *testing.T) {
+	type event struct {
+		At   time.Time   `json:"at"`
+		Next *time.Time  `json:"next"`
+		All  []time.Time `json:"all,omitempty"`
+	}
+	next := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	cases := []struct {
+		v      event
+		format TimeFormat
+		want   string
+	}{
+		{event{At: next}, TimeRFC3339, "(at:'2006-01-02T15:04:05Z',next:!n)"},
+		{event{At: next, Next: &next}, TimeUnixSeconds, "(at:1136214245,next:1136214245)"},
+		{event{At: next.Add(500 * time.Millisecond)}, TimeUnixSeconds, "(at:1136214245.5,next:!n)"},
+		{event{At: time.Date(1969, 12, 31, 23, 59, 58, 250000000, time.UTC)}, TimeUnixSeconds, "(at:-1.75,next:!n)"},
+		{event{At: next, All: []time.Time{next, next.Add(time.Nanosecond)}}, TimeUnixSeconds, "(all:!(1136214245,1136214245.000000001),at:1136214245,next:!n)"},
+	}
+	for _, c := range cases {
+		r, err := MarshalWithOptions(c.v, Rison, EncodeOptions{TimeFormat: c.format})
+		if err != nil {
+			t.Errorf("encoding %v : want no error, got error `%s`", c.v, err.Error())
+			continue
+		}
+		if string(r) != c.want {
+			t.Errorf("encoding %v : want %s, got %s", c.v, c.want, r)
+		}
+		var got event
+		err = UnmarshalWithOptions(r, &got, Rison, DecodeOptions{TimeFormat: c.format})
+		if err != nil {
+			t.Errorf("decoding %s : want no error, got error `%s`", r, err.Error())
+			continue
+		}
+		if !got.At.Equal(c.v.At) || (got.Next == nil) != (c.v.Next == nil) || got.Next != nil && !got.Next.Equal(*c.v.Next) || len(got.All) != len(c.v.All) {
+			t.Errorf("decoding %s : want %v, got %v", r, c.v, got)
+			continue
+		}
+		for i := range got.All {
+			if !got.All[i].Equal(c.v.All[i]) {
+				t.Errorf("decoding %s : want %v, got %v", r, c.v, got)
+			}
+		}
+	}
+
+	var v struct {
+		At time.Time `json:"at"`
+		N  int       `json:"n"`
+	}
+	err := UnmarshalWithOptions([]byte("(at:1.5,n:x)"), &v, Rison, DecodeOptions{TimeFormat: TimeUnixSeconds})
+	e, ok := err.(*ParseError)
+	if !ok || e.Type != ETypeMismatch || e.Pos != 10 {
+		t.Errorf("decoding (at:1.5,n:x) : want ETypeMismatch at 10, got %#v", err)
+	}
+
+	opts := DecodeOptions{TimeFormat: TimeUnixSeconds}
+	j, err := ToJSONWithOptions([]byte("(a:1e3,b:1.50)"), Rison, opts)
+	if want := `{"a":1000,"b":1.5}`; err != nil || string(j) != want {
+		t.Errorf("converting (a:1e3,b:1.50) : want %s, got %s, %v", want, j, err)
+	}
+	var w struct {
+		At time.Time   `json:"at"`
+		N  json.Number `json:"n"`
+	}
+	err = UnmarshalWithOptions([]byte("(at:1136214245.000000001e0,n:1.50)"), &w, Rison, opts)
+	if err != nil || !w.At.Equal(next.Add(time.Nanosecond)) || w.N != "1.5" {
+		t.Errorf("decoding (at:1136214245.000000001e0,n:1.50) : want the exact time and n 1.5, got %v, %s, %v", w.At, w.N, err)
+	}
+
+	// only the pointers to time.Time
+	r, err := MarshalWithOptions(next, Rison, EncodeOptions{TimeFormat: TimeUnixSeconds})
+	var tm time.Time
+	if err == nil {
+		err = UnmarshalWithOptions(r, &tm, Rison, opts)
+	}
+	if err != nil || !tm.Equal(next) {
+		t.Errorf("decoding %s into *time.Time : want %v, got %v, %v", r, next, tm, err)
+	}
+	var ptr struct {
+		P *time.Time `json:"p"`
+	}
+	err = UnmarshalWithOptions([]byte("(p:1136214245)"), &ptr, Rison, opts)
+	if err != nil || ptr.P == nil || !ptr.P.Equal(next) {
+		t.Errorf("decoding (p:1136214245) : want %v, got %v, %v", next, ptr.P, err)
+	}
+	var ptrs []*time.Time
+	err = UnmarshalWithOptions([]byte("!(1136214245,!n)"), &ptrs, Rison, opts)
+	if err != nil || len(ptrs) != 2 || ptrs[0] == nil || !ptrs[0].Equal(next) || ptrs[1] != nil {
+		t.Errorf("decoding !(1136214245,!n) : want [%v <nil>], got %v, %v", next, ptrs, err)
+	}
+}
@@ -0,0 +1,188 @@
+package rison
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// TokenType is the type of a token.
+type TokenType int
+
+const (
+	// TokenBeginObject is the type of "(" starting an object.
+	TokenBeginObject TokenType = iota
+	// TokenEndObject is the type of ")" ending an object.
+	TokenEndObject
+	// TokenBeginArray is the type of "!(" starting an array.
+	TokenBeginArray
+	// TokenEndArray is the type of ")" ending an array.
+	TokenEndArray
+	// TokenKey is the type of object keys.
+	TokenKey
+	// TokenColon is the type of ":" after object keys.
+	TokenColon
+	// TokenComma is the type of "," between elements and members.
+	TokenComma
+	// TokenString is the type of strings.
+	TokenString
+	// TokenNumber is the type of numbers.
+	TokenNumber
+	// TokenBool is the type of !t and !f.
+	TokenBool
+	// TokenNull is the type of !n.
+	TokenNull
+)
+
+// Token is a token of Rison.
+type Token struct {
+	Type TokenType
+
+	// Value is the value of a key or a scalar:
+	// a string, a float64, a bool or nil.
+	Value interface{}
+
+	// Start and End are the offsets of the first byte of the token and
+	// next to the last byte in the input. The parentheses wrapping the
+	// O-Rison and the A-Rison, which are not in the input, have the
+	// empty spans at the ends of the input.
+	Start int
+	End   int
+}
+
+// Tokenize parses the Rison-encoded data and returns the tokens.
+// The errors are the same as Decode.
+func Tokenize(data []byte, m Mode) ([]Token, error) {
+	return TokenizeWithOptions(data, m, DecodeOptions{})
+}
+
+// TokenizeWithOptions is like Tokenize but parses with the options.
+func TokenizeWithOptions(data []byte, m Mode, opts DecodeOptions) ([]Token, error) {
+	c := &tokenCollector{mode: m, n: len(data)}
+	err := (&parser{Mode: m, DecodeOptions: opts}).walk(data, c)
+	if err != nil {
+		return nil, err
+	}
+	return c.tokens, nil
+}
+
+// tokenCollector is a handler which collects the tokens.
+type tokenCollector struct {
+	mode   Mode
+	n      int
+	tokens []Token
+}
+
+func (c *tokenCollector) add(typ TokenType, v interface{}, start, end int) {
+	c.tokens = append(c.tokens, Token{
+		Type:  typ,
+		Value: v,
+		Start: inputOffset(start, c.mode, c.n),
+		End:   inputOffset(end, c.mode, c.n),
+	})
+}
+
+func (c *tokenCollector) beginObject(start int) {
+	c.add(TokenBeginObject, nil, start, start+1)
+}
+
+func (c *tokenCollector) endObject(end int) {
+	c.add(TokenEndObject, nil, end-1, end)
+}
+
+func (c *tokenCollector) beginArray(start int) {
+	c.add(TokenBeginArray, nil, start, start+2)
+}
+
+func (c *tokenCollector) endArray(end int) {
+	c.add(TokenEndArray, nil, end-1, end)
+}
+
+func (c *tokenCollector) comma(pos int) {
+	c.add(TokenComma, nil, pos, pos+1)
+}
+
+func (c *tokenCollector) colon(pos int) {
+	c.add(TokenColon, nil, pos, pos+1)
+}
+
+func (c *tokenCollector) key(s []byte, start, end int) {
+	c.add(TokenKey, string(s), start, end)
+}
+
+func (c *tokenCollector) null(start, end int) {
+	c.add(TokenNull, nil, start, end)
+}
+
+func (c *tokenCollector) boolean(v bool, start, end int) {
+	c.add(TokenBool, v, start, end)
+}
+
+func (c *tokenCollector) number(j []byte, start, end int) {
+	var f float64
+	_ = json.Unmarshal(j, &f) // j is always a valid JSON number
+	c.add(TokenNumber, f, start, end)
+}
+
+func (c *tokenCollector) string(s []byte, start, end int) {
+	c.add(TokenString, string(s), start, end)
+}
+
+// Tokenizer reads the tokens of Rison from an io.Reader.
+//
+// It reads the whole input at the first call of Next and parses it with
+// the same parser as TokenizeWithOptions, so that the tokens, the errors
+// and the options are the same as it. The tokens before an error are
+// returned before the error. It holds the input and the tokens in the
+// memory until the end.
+type Tokenizer struct {
+	r      io.Reader
+	p      parser
+	tokens []Token
+	offset int
+	read   bool
+	err    error // returned after the tokens
+}
+
+// NewTokenizer returns a new Tokenizer reading from r in the mode m.
+func NewTokenizer(r io.Reader, m Mode) *Tokenizer {
+	return NewTokenizerWithOptions(r, m, DecodeOptions{})
+}
+
+// NewTokenizerWithOptions is like NewTokenizer but parses with the options.
+func NewTokenizerWithOptions(r io.Reader, m Mode, opts DecodeOptions) *Tokenizer {
+	return &Tokenizer{r: r, p: parser{Mode: m, DecodeOptions: opts}}
+}
+
+// InputOffset returns the offset in the input of the byte next to the
+// last token or the byte where the error occurred.
+func (t *Tokenizer) InputOffset() int {
+	return t.offset
+}
+
+// Next returns the next token. It returns io.EOF at the end of the input.
+func (t *Tokenizer) Next() (Token, error) {
+	if !t.read {
+		t.read = true
+		data, err := io.ReadAll(t.r)
+		if err != nil {
+			t.err = err
+			return Token{}, err
+		}
+		c := &tokenCollector{mode: t.p.Mode, n: len(data)}
+		t.err = t.p.walk(data, c)
+		t.tokens = c.tokens
+	}
+	if 0 < len(t.tokens) {
+		tok := t.tokens[0]
+		t.tokens = t.tokens[1:]
+		t.offset = tok.End
+		return tok, nil
+	}
+	if t.err == nil {
+		return Token{}, io.EOF
+	}
+	if e, ok := t.err.(*ParseError); ok {
+		t.offset = e.Pos
+	}
+	return Token{}, t.err
+}
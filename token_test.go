@@ -0,0 +1,173 @@
+package rison
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	toks, err := Tokenize([]byte("(a:0,b:!(1,'x y'),c:!t,d:!n)"), Rison)
+	if err != nil {
+		t.Fatalf("tokenizing : want no error, got error `%s`", err.Error())
+	}
+	want := []Token{
+		{Type: TokenBeginObject, Start: 0, End: 1},
+		{Type: TokenKey, Value: "a", Start: 1, End: 2},
+		{Type: TokenColon, Start: 2, End: 3},
+		{Type: TokenNumber, Value: float64(0), Start: 3, End: 4},
+		{Type: TokenComma, Start: 4, End: 5},
+		{Type: TokenKey, Value: "b", Start: 5, End: 6},
+		{Type: TokenColon, Start: 6, End: 7},
+		{Type: TokenBeginArray, Start: 7, End: 9},
+		{Type: TokenNumber, Value: float64(1), Start: 9, End: 10},
+		{Type: TokenComma, Start: 10, End: 11},
+		{Type: TokenString, Value: "x y", Start: 11, End: 16},
+		{Type: TokenEndArray, Start: 16, End: 17},
+		{Type: TokenComma, Start: 17, End: 18},
+		{Type: TokenKey, Value: "c", Start: 18, End: 19},
+		{Type: TokenColon, Start: 19, End: 20},
+		{Type: TokenBool, Value: true, Start: 20, End: 22},
+		{Type: TokenComma, Start: 22, End: 23},
+		{Type: TokenKey, Value: "d", Start: 23, End: 24},
+		{Type: TokenColon, Start: 24, End: 25},
+		{Type: TokenNull, Start: 25, End: 27},
+		{Type: TokenEndObject, Start: 27, End: 28},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("tokenizing : want %+v, got %+v", want, toks)
+	}
+}
+
+// readAllTokens reads the tokens from a Tokenizer until io.EOF or an error.
+func readAllTokens(tk *Tokenizer) ([]Token, error) {
+	var toks []Token
+	for {
+		tok, err := tk.Next()
+		if err == io.EOF {
+			return toks, nil
+		}
+		if err != nil {
+			return toks, err
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("(items:!(")
+	for i := 0; i < 1000; i++ {
+		if 0 < i {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "(id:%d,name:'item %d!'s',tags:!(a,'-b',!t,!f,!n),price:%d.5e-1,empty:(),none:!())", i, i, -i)
+	}
+	b.WriteString("),'':x)")
+	large := b.String()
+
+	cases := []struct {
+		s string
+		m Mode
+	}{
+		{large, Rison},
+		{"a:1,b:!(x,(c:'d'))", ORison},
+		{"", ORison},
+		{"1,abc,!(!t)", ARison},
+		{"", ARison},
+		{"-1.5e3", Rison},
+		{"'it!'s'", Rison},
+		{"abc", Rison},
+	}
+	for _, c := range cases {
+		want, err := Tokenize([]byte(c.s), c.m)
+		if err != nil {
+			t.Errorf("tokenizing %s : want no error, got error `%s`", c.s, err.Error())
+			continue
+		}
+		tk := NewTokenizer(strings.NewReader(c.s), c.m)
+		got, err := readAllTokens(tk)
+		if err != nil {
+			t.Errorf("tokenizing %s : want no error, got error `%s`", c.s, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tokenizing %s : want %+v, got %+v", c.s, want, got)
+		}
+		if tok, err := tk.Next(); err != io.EOF {
+			t.Errorf("tokenizing %s : want io.EOF after the end, got %+v, %v", c.s, tok, err)
+		}
+	}
+
+	for _, s := range []string{"", "(a:", "(a", "!(1,", "'abc", "!x", "1E5", "(a:1)x", "(a:1))", "(,)", "(1:2)", "!(1,,2)", "(a:b:c)", "!"} {
+		_, want := Tokenize([]byte(s), Rison)
+		_, err := readAllTokens(NewTokenizer(strings.NewReader(s), Rison))
+		var pe *ParseError
+		if !errors.As(err, &pe) || !reflect.DeepEqual(err, want) {
+			t.Errorf("tokenizing %s : want %#v, got %#v", s, want, err)
+		}
+	}
+
+	// the options are the same as the parser's
+	opts := DecodeOptions{AllowComments: true, SkipWhitespaces: true}
+	s := "( a: 1, # one\n b: !( x ) )"
+	want, err := TokenizeWithOptions([]byte(s), Rison, opts)
+	if err != nil {
+		t.Fatalf("tokenizing %q : want no error, got error `%s`", s, err.Error())
+	}
+	got, err := readAllTokens(NewTokenizerWithOptions(strings.NewReader(s), Rison, opts))
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenizing %q with the options : want %+v, got %+v, %v", s, want, got, err)
+	}
+	if _, err := readAllTokens(NewTokenizer(strings.NewReader(s), Rison)); err == nil {
+		t.Errorf("tokenizing %q without the options : want an error, got nil", s)
+	}
+
+	// the tokens before an error are returned before it
+	tk := NewTokenizer(strings.NewReader("!(1,!x)"), Rison)
+	got, err = readAllTokens(tk)
+	if len(got) != 3 || err == nil || tk.InputOffset() != 5 {
+		t.Errorf("tokenizing !(1,!x) : want 3 tokens and an error at 5, got %+v, %v at %d", got, err, tk.InputOffset())
+	}
+}
+
+func TestTokenizeNested(t *testing.T) {
+	r := "(a:0,b:!(1,2))"
+	toks, err := Tokenize([]byte(r), Rison)
+	if err != nil {
+		t.Fatalf("tokenizing %s : want no error, got error `%s`", r, err.Error())
+	}
+	want := []Token{
+		{Type: TokenBeginObject, Start: 0, End: 1},
+		{Type: TokenKey, Value: "a", Start: 1, End: 2},
+		{Type: TokenColon, Start: 2, End: 3},
+		{Type: TokenNumber, Value: float64(0), Start: 3, End: 4},
+		{Type: TokenComma, Start: 4, End: 5},
+		{Type: TokenKey, Value: "b", Start: 5, End: 6},
+		{Type: TokenColon, Start: 6, End: 7},
+		{Type: TokenBeginArray, Start: 7, End: 9},
+		{Type: TokenNumber, Value: float64(1), Start: 9, End: 10},
+		{Type: TokenComma, Start: 10, End: 11},
+		{Type: TokenNumber, Value: float64(2), Start: 11, End: 12},
+		{Type: TokenEndArray, Start: 12, End: 13},
+		{Type: TokenEndObject, Start: 13, End: 14},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("tokenizing %s : want %+v, got %+v", r, want, toks)
+	}
+	streamed, err := readAllTokens(NewTokenizer(strings.NewReader(r), Rison))
+	if err != nil || !reflect.DeepEqual(streamed, want) {
+		t.Errorf("tokenizing %s from a reader : want %+v, got %+v, %v", r, want, streamed, err)
+	}
+
+	for _, s := range []string{"(a:0,b:!(1,2)", "(a:0,b:!(1,,2))", "(a:0,b:!(1,2)))", "(a:0,b:!x)"} {
+		_, want := Decode([]byte(s), Rison)
+		_, err := Tokenize([]byte(s), Rison)
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("tokenizing %s : want the same error as Decode %#v, got %#v", s, want, err)
+		}
+	}
+}
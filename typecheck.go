@@ -0,0 +1,204 @@
+package rison
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// needsTypeCheck reports whether a value of type t can hold a Go array
+// or a map with the keys decoded by UnmarshalText, which are checked
+// by checkTypes before decoded by "encoding/json".
+func needsTypeCheck(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	if decodesItself(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Array:
+		return true
+	case reflect.Map:
+		return reflect.PtrTo(t.Key()).Implements(textUnmarshalerType) || needsTypeCheck(t.Elem(), seen)
+	case reflect.Ptr, reflect.Slice:
+		return needsTypeCheck(t.Elem(), seen)
+	case reflect.Struct:
+		for _, f := range structFields(t) {
+			if needsTypeCheck(f.typ, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodesItself reports whether a value of type t is decoded by its own
+// UnmarshalRison, UnmarshalJSON or UnmarshalText method.
+func decodesItself(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(unmarshalerType) || t.Implements(jsonUnmarshalerType) || t.Implements(textUnmarshalerType)
+}
+
+// checkTypes reports the errors in the JSON-encoded data j to be
+// decoded into a value of type t, which "encoding/json" does not report
+// with the positions:
+//
+//   - an array decoded into a Go array of a different length, which
+//     "encoding/json" fills with zero values or truncates silently
+//   - an object key rejected by UnmarshalText of the map key type
+//   - an object key with no struct field, with DisallowUnknownFields
+func (p *parser) checkTypes(j []byte, t reflect.Type) error {
+	if !p.DisallowUnknownFields && !needsTypeCheck(t, map[reflect.Type]bool{}) {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	p.path = p.path[:0]
+	return p.checkType(dec, t)
+}
+
+func (p *parser) checkType(dec *json.Decoder, t reflect.Type) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && decodesItself(t) {
+		t = nil
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('['):
+		start := int(dec.InputOffset()) - 1
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Array || t.Kind() == reflect.Slice) {
+			elem = t.Elem()
+		}
+		n := 0
+		for dec.More() {
+			p.path = append(p.path, pathElem{index: n})
+			err = p.checkType(dec, elem)
+			if err != nil {
+				return err
+			}
+			p.path = p.path[:len(p.path)-1]
+			n++
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if t != nil && t.Kind() == reflect.Array && n != t.Len() {
+			return p.errorAt(p.risonIndex(start+1), nil, EArrayLength, n, p.fieldPath(), t.String(), t.Len())
+		}
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			err = p.checkKey(t, key, int(dec.InputOffset()))
+			if err != nil {
+				return err
+			}
+			p.path = append(p.path, pathElem{key: key, index: -1})
+			err = p.checkType(dec, memberType(t, key))
+			if err != nil {
+				return err
+			}
+			p.path = p.path[:len(p.path)-1]
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKey reports an error if the key ending at the offset in the JSON
+// is rejected by UnmarshalText of the key type of the map type t, or
+// if it has no field in the struct type t with DisallowUnknownFields.
+func (p *parser) checkKey(t reflect.Type, key string, offset int) error {
+	if t == nil {
+		return nil
+	}
+	start := func() int {
+		j, _ := json.Marshal(key) // the same encoding as the parser writes
+		return p.risonIndex(offset - len(j) + 1)
+	}
+	if t.Kind() == reflect.Struct {
+		if p.DisallowUnknownFields && memberType(t, key) == nil && restFieldIndex(t) < 0 {
+			return p.errorAt(start(), nil, EUnknownField, key, t.String())
+		}
+		return nil
+	}
+	if t.Kind() != reflect.Map || !reflect.PtrTo(t.Key()).Implements(textUnmarshalerType) {
+		return nil
+	}
+	err := reflect.New(t.Key()).Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key))
+	if err == nil {
+		return nil
+	}
+	return p.errorAt(start(), err, EInvalidKey, key, t.Key().String(), err.Error())
+}
+
+// fieldPath returns the path to the value being checked in the form
+// of the field in *json.UnmarshalTypeError, such as .items[1].name.
+func (p *parser) fieldPath() string {
+	return formatPath(p.path)
+}
+
+// formatPath returns the path in the form of .items[1].name,
+// or "." for the empty path.
+func formatPath(path []pathElem) string {
+	if len(path) == 0 {
+		return "."
+	}
+	var b strings.Builder
+	for _, e := range path {
+		if 0 <= e.index {
+			fmt.Fprintf(&b, "[%d]", e.index)
+		} else {
+			b.WriteString("." + e.key)
+		}
+	}
+	return b.String()
+}
+
+// memberType returns the type of the member of the key in a value of
+// type t, matching the struct fields in the same way as "encoding/json".
+func memberType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		var folded reflect.Type
+		for _, f := range structFields(t) {
+			if f.name == key {
+				return f.typ
+			}
+			if folded == nil && strings.EqualFold(f.name, key) {
+				folded = f.typ
+			}
+		}
+		return folded
+	}
+	return nil
+}